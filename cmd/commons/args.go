@@ -3,11 +3,8 @@ package commons
 import (
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 
-	"gopkg.in/natefinch/lumberjack.v2"
-
 	"github.com/cyverse/s3-data-watcher/commons"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -26,6 +23,25 @@ func SetCommonFlags(command *cobra.Command) {
 	command.Flags().Bool(ChildProcessArgument, false, "")
 }
 
+// SetControlFlags sets flags for daemon control commands
+func SetControlFlags(command *cobra.Command) {
+	command.Flags().StringP("config", "c", commons.ConfigFilePathDefault, "Set config file (yaml)")
+}
+
+// ReadConfigFromFlags reads a config file given via "config" flag
+func ReadConfigFromFlags(command *cobra.Command) (*commons.Config, error) {
+	configPath := commons.ConfigFilePathDefault
+
+	configFlag := command.Flags().Lookup("config")
+	if configFlag != nil {
+		if len(configFlag.Value.String()) > 0 {
+			configPath = configFlag.Value.String()
+		}
+	}
+
+	return commons.NewConfigFromYAMLFile(configPath)
+}
+
 func ProcessCommonFlags(command *cobra.Command) (*commons.Config, io.WriteCloser, bool, error) {
 	logger := log.WithFields(log.Fields{
 		"package":  "commons",
@@ -72,52 +88,10 @@ func ProcessCommonFlags(command *cobra.Command) (*commons.Config, io.WriteCloser
 		}
 	}
 
-	readConfig := false
-	var config *commons.Config
-
-	configFlag := command.Flags().Lookup("config")
-	if configFlag != nil {
-		configPath := configFlag.Value.String()
-		if len(configPath) > 0 {
-			yamlBytes, err := os.ReadFile(configPath)
-			if err != nil {
-				logger.Error(err)
-				return nil, nil, false, err // stop here
-			}
-
-			serverConfig, err := commons.NewConfigFromYAML(yamlBytes)
-			if err != nil {
-				logger.Error(err)
-				return nil, nil, false, err // stop here
-			}
-
-			// overwrite config
-			config = serverConfig
-			readConfig = true
-		}
-	}
-
-	// default config
-	if !readConfig {
-		yamlBytes, err := os.ReadFile(commons.ConfigFilePathDefault)
-		if err != nil {
-			logger.Error(err)
-			return nil, nil, false, err // stop here
-		}
-
-		serverConfig, err := commons.NewConfigFromYAML(yamlBytes)
-		if err != nil {
-			logger.Error(err)
-			return nil, nil, false, err // stop here
-		}
-
-		// overwrite config
-		config = serverConfig
-		readConfig = true
-	}
-
-	if !readConfig {
-		config = commons.NewDefaultConfig()
+	config, err := ReadConfigFromFlags(command)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, false, err // stop here
 	}
 
 	// prioritize command-line flag over config files
@@ -132,29 +106,22 @@ func ProcessCommonFlags(command *cobra.Command) (*commons.Config, io.WriteCloser
 
 	config.ChildProcess = childProcess
 
-	err := config.MakeLogDir()
+	err = config.MakeLogDir()
 	if err != nil {
 		logger.Error(err)
 		return nil, nil, false, err // stop here
 	}
 
-	if config.Debug {
-		log.SetLevel(log.DebugLevel)
+	err = ConfigureLogger(config)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, false, err // stop here
 	}
 
-	var logWriter io.WriteCloser
-	logFilePath := config.GetLogFilePath()
-	if logFilePath == "-" || len(logFilePath) == 0 {
-		log.SetOutput(os.Stderr)
-	} else {
-		parentLogWriter, parentLogFilePath := getLogWriterForParentProcess(logFilePath)
-		logWriter = parentLogWriter
-
-		// use multi output - to output to file and stdout
-		mw := io.MultiWriter(os.Stderr, parentLogWriter)
-		log.SetOutput(mw)
-
-		logger.Infof("Logging to %s", parentLogFilePath)
+	logWriter, err := SetLogOutputForParentProcess(config)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, false, err // stop here
 	}
 
 	err = config.Validate()
@@ -179,25 +146,3 @@ func PrintVersion(command *cobra.Command) error {
 func PrintHelp(command *cobra.Command) error {
 	return command.Usage()
 }
-
-func getLogWriterForParentProcess(logPath string) (io.WriteCloser, string) {
-	logFilePath := fmt.Sprintf("%s.parent", logPath)
-	return &lumberjack.Logger{
-		Filename:   logFilePath,
-		MaxSize:    50, // 50MB
-		MaxBackups: 5,
-		MaxAge:     30, // 30 days
-		Compress:   false,
-	}, logFilePath
-}
-
-func getLogWriterForChildProcess(logPath string) (io.WriteCloser, string) {
-	logFilePath := fmt.Sprintf("%s.child", logPath)
-	return &lumberjack.Logger{
-		Filename:   logFilePath,
-		MaxSize:    50, // 50MB
-		MaxBackups: 5,
-		MaxAge:     30, // 30 days
-		Compress:   false,
-	}, logFilePath
-}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 
 	"github.com/cyverse/s3-data-watcher/commons"
 	log "github.com/sirupsen/logrus"
@@ -53,6 +54,12 @@ func RunChildProcess(serverExec string) (io.WriteCloser, io.ReadCloser, error) {
 	logger.Info("Running the child process in the background mode")
 	childProcessArgument := fmt.Sprintf("--%s", ChildProcessArgument)
 	cmd := exec.Command(serverExec, childProcessArgument)
+
+	// detach from parent's session and process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+
 	childStdin, err := cmd.StdinPipe()
 	if err != nil {
 		logger.WithError(err).Error("failed to get the child process's STDIN")
@@ -86,6 +93,9 @@ func ParentProcessSendConfigViaSTDIN(config *commons.Config, stdin io.WriteClose
 	})
 
 	logger.Info("Sending configuration via STDIN")
+
+	// the child process reads the config over defaults, so fields whose 0 is meaningful and
+	// differs from the default (e.g. drain_timeout, sample_ratio) must not be omitempty
 	configBytes, err := yaml.Marshal(config)
 	if err != nil {
 		logger.WithError(err).Error("failed to serialize configuration")
@@ -126,6 +136,11 @@ func ParentProcessSendConfigViaSTDIN(config *commons.Config, stdin io.WriteClose
 				childProcessFailed = true
 				break
 			}
+
+			// EOF - child process exited without reporting
+			logger.Error("child process exited unexpectedly")
+			childProcessFailed = true
+			break
 		}
 	}
 
@@ -166,8 +181,10 @@ func ChildProcessReadConfigViaSTDIN() (*commons.Config, io.WriteCloser, error) {
 		return nil, nil, err
 	}
 
-	if config.Debug {
-		log.SetLevel(log.DebugLevel)
+	err = ConfigureLogger(config)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, err
 	}
 
 	err = config.Validate()
@@ -182,14 +199,10 @@ func ChildProcessReadConfigViaSTDIN() (*commons.Config, io.WriteCloser, error) {
 		return nil, nil, err
 	}
 
-	// output to log file
-	var logWriter io.WriteCloser
-	logFilePath := config.GetLogFilePath()
-	if len(logFilePath) > 0 {
-		logWriter, childLogFilePath := getLogWriterForChildProcess(logFilePath)
-		log.SetOutput(logWriter)
-
-		logger.Infof("Logging to %s", childLogFilePath)
+	logWriter, err := SetLogOutputForChildProcess(config)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, err
 	}
 
 	return config, logWriter, nil
@@ -0,0 +1,236 @@
+package commons
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	logrus_syslog "github.com/sirupsen/logrus/hooks/syslog"
+	"golang.org/x/xerrors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	logTimestampFormat string = "2006-01-02 15:04:05.000000"
+)
+
+// SetLogFormatter sets log formatter for the given format
+func SetLogFormatter(format string) {
+	switch format {
+	case commons.LogFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{
+			TimestampFormat: logTimestampFormat,
+		})
+	case commons.LogFormatLogfmt:
+		log.SetFormatter(&log.TextFormatter{
+			TimestampFormat:  logTimestampFormat,
+			FullTimestamp:    true,
+			DisableColors:    true,
+			QuoteEmptyFields: true,
+		})
+	default:
+		log.SetFormatter(&log.TextFormatter{
+			TimestampFormat: logTimestampFormat,
+			FullTimestamp:   true,
+		})
+	}
+}
+
+// ConfigureLogger sets log format and level given in config
+func ConfigureLogger(config *commons.Config) error {
+	level, err := config.GetLogLevel()
+	if err != nil {
+		return err
+	}
+
+	SetLogFormatter(config.LogFormat)
+	log.SetLevel(level)
+	return nil
+}
+
+// SetLogOutputForParentProcess sets log output of parent process
+// returns a log writer to close on exit
+func SetLogOutputForParentProcess(config *commons.Config) (io.WriteCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "commons",
+		"function": "SetLogOutputForParentProcess",
+	})
+
+	switch config.LogOutput {
+	case commons.LogOutputSyslog, commons.LogOutputJournald:
+		err := addLogHook(config)
+		if err != nil {
+			return nil, err
+		}
+
+		// mirror to terminal for interactive use
+		if isTerminal(os.Stderr) {
+			log.SetOutput(os.Stderr)
+		} else {
+			SetNilLogWriter()
+		}
+
+		return nil, nil
+	}
+
+	logFilePath := config.GetLogFilePath()
+	if logFilePath == "-" || len(logFilePath) == 0 {
+		log.SetOutput(os.Stderr)
+		return nil, nil
+	}
+
+	parentLogWriter, parentLogFilePath := getLogWriterForParentProcess(logFilePath, &config.LogRotation)
+
+	// use multi output - to output to file and stdout
+	mw := io.MultiWriter(os.Stderr, parentLogWriter)
+	log.SetOutput(mw)
+
+	logger.Infof("Logging to %s", parentLogFilePath)
+	return parentLogWriter, nil
+}
+
+// SetLogOutputForChildProcess sets log output of child process running in background
+// returns a log writer to close on exit
+func SetLogOutputForChildProcess(config *commons.Config) (io.WriteCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "commons",
+		"function": "SetLogOutputForChildProcess",
+	})
+
+	switch config.LogOutput {
+	case commons.LogOutputSyslog, commons.LogOutputJournald:
+		err := addLogHook(config)
+		if err != nil {
+			return nil, err
+		}
+
+		SetNilLogWriter()
+		return nil, nil
+	}
+
+	logFilePath := config.GetLogFilePath()
+	if logFilePath == "-" || len(logFilePath) == 0 {
+		return nil, nil
+	}
+
+	childLogWriter, childLogFilePath := getLogWriterForChildProcess(logFilePath, &config.LogRotation)
+	log.SetOutput(childLogWriter)
+
+	logger.Infof("Logging to %s", childLogFilePath)
+	return childLogWriter, nil
+}
+
+func getLogWriterForParentProcess(logPath string, rotation *commons.LogRotationConfig) (io.WriteCloser, string) {
+	logFilePath := fmt.Sprintf("%s.parent", logPath)
+	return newRotatingLogWriter(logFilePath, rotation), logFilePath
+}
+
+func getLogWriterForChildProcess(logPath string, rotation *commons.LogRotationConfig) (io.WriteCloser, string) {
+	logFilePath := fmt.Sprintf("%s.child", logPath)
+	return newRotatingLogWriter(logFilePath, rotation), logFilePath
+}
+
+func newRotatingLogWriter(logFilePath string, rotation *commons.LogRotationConfig) io.WriteCloser {
+	return &lumberjack.Logger{
+		Filename:   logFilePath,
+		MaxSize:    rotation.MaxSize,
+		MaxBackups: rotation.MaxBackups,
+		MaxAge:     rotation.MaxAge,
+		Compress:   rotation.Compress,
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+// addLogHook adds a hook sending logs to syslog or journald
+func addLogHook(config *commons.Config) error {
+	switch config.LogOutput {
+	case commons.LogOutputSyslog:
+		hook, err := logrus_syslog.NewSyslogHook(config.Syslog.Network, config.Syslog.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, config.LogTag)
+		if err != nil {
+			return xerrors.Errorf("failed to connect to syslog: %w", err)
+		}
+
+		log.AddHook(hook)
+	case commons.LogOutputJournald:
+		if !journal.Enabled() {
+			return xerrors.Errorf("journald is not available")
+		}
+
+		log.AddHook(&journaldHook{
+			identifier: config.LogTag,
+		})
+	}
+
+	return nil
+}
+
+// journaldHook sends logs to journald with fields
+type journaldHook struct {
+	identifier string
+}
+
+// Levels returns levels the hook fires for
+func (hook *journaldHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire sends the log entry to journald
+func (hook *journaldHook) Fire(entry *log.Entry) error {
+	vars := map[string]string{
+		"SYSLOG_IDENTIFIER": hook.identifier,
+	}
+
+	for key, value := range entry.Data {
+		vars[getJournaldFieldName(key)] = fmt.Sprint(value)
+	}
+
+	return journal.Send(entry.Message, getJournaldPriority(entry.Level), vars)
+}
+
+// getJournaldFieldName converts a log field name to a journald field name
+// journald field names consist of uppercase letters, digits and underscores
+func getJournaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	// must not start with an underscore or a digit
+	return "F_" + strings.TrimLeft(name, "_")
+}
+
+func getJournaldPriority(level log.Level) journal.Priority {
+	switch level {
+	case log.PanicLevel:
+		return journal.PriEmerg
+	case log.FatalLevel:
+		return journal.PriCrit
+	case log.ErrorLevel:
+		return journal.PriErr
+	case log.WarnLevel:
+		return journal.PriWarning
+	case log.InfoLevel:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
+	}
+}
@@ -0,0 +1,106 @@
+package commons
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// IsProcessRunning checks if a process with the given pid is alive
+func IsProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	err := syscall.Kill(pid, 0)
+	if err == nil {
+		return true
+	}
+
+	// the process exists but is owned by other user
+	return err == syscall.EPERM
+}
+
+// ReadPIDFile reads a pid from the given PID file
+func ReadPIDFile(pidFilePath string) (int, error) {
+	pidBytes, err := os.ReadFile(pidFilePath)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		return 0, xerrors.Errorf("failed to parse pid file (%s): %w", pidFilePath, err)
+	}
+
+	return pid, nil
+}
+
+// GetRunningPID returns the pid recorded in the PID file if the process is alive
+func GetRunningPID(pidFilePath string) (int, bool) {
+	pid, err := ReadPIDFile(pidFilePath)
+	if err != nil {
+		return 0, false
+	}
+
+	if !IsProcessRunning(pid) {
+		return pid, false
+	}
+
+	return pid, true
+}
+
+// WritePIDFile writes the pid of current process to the given PID file
+// returns error if other instance recorded in the file is still running
+func WritePIDFile(pidFilePath string) error {
+	for {
+		pidFile, err := os.OpenFile(pidFilePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if !os.IsExist(err) {
+				return xerrors.Errorf("failed to create pid file (%s): %w", pidFilePath, err)
+			}
+
+			pid, running := GetRunningPID(pidFilePath)
+			if running && pid != os.Getpid() {
+				return xerrors.Errorf("s3-data-watcher is already running (pid %d)", pid)
+			}
+
+			// stale
+			err = os.Remove(pidFilePath)
+			if err != nil && !os.IsNotExist(err) {
+				return xerrors.Errorf("failed to remove stale pid file (%s): %w", pidFilePath, err)
+			}
+			continue
+		}
+
+		_, err = fmt.Fprintf(pidFile, "%d\n", os.Getpid())
+		if err != nil {
+			pidFile.Close()
+			return xerrors.Errorf("failed to write pid file (%s): %w", pidFilePath, err)
+		}
+
+		return pidFile.Close()
+	}
+}
+
+// RemovePIDFile removes the PID file if it is owned by current process
+func RemovePIDFile(pidFilePath string) error {
+	pid, err := ReadPIDFile(pidFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if pid != os.Getpid() {
+		// owned by other process
+		return nil
+	}
+
+	return os.Remove(pidFilePath)
+}
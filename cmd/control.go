@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	cmd_commons "github.com/cyverse/s3-data-watcher/cmd/commons"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+const (
+	stopTimeoutMargin time.Duration = 10 * time.Second
+	stopCheckInterval time.Duration = 200 * time.Millisecond
+
+	// LSB exit code for "program is not running"
+	statusNotRunningExitCode int = 3
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop running s3-data-watcher",
+	Long:  "Stop running s3-data-watcher recorded in the PID file.",
+	RunE:  processStopCommand,
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show status of s3-data-watcher",
+	Long:  "Show status of s3-data-watcher recorded in the PID file.",
+	RunE:  processStatusCommand,
+}
+
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart s3-data-watcher",
+	Long:  "Stop running s3-data-watcher and start it again.",
+	RunE:  processRestartCommand,
+}
+
+func processStopCommand(command *cobra.Command, args []string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "processStopCommand",
+	})
+
+	config, err := cmd_commons.ReadConfigFromFlags(command)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	err = stopRunningInstance(config)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	return nil
+}
+
+func processStatusCommand(command *cobra.Command, args []string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "processStatusCommand",
+	})
+
+	config, err := cmd_commons.ReadConfigFromFlags(command)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	pid, running := cmd_commons.GetRunningPID(config.GetPIDFilePath())
+	if !running {
+		fmt.Println("s3-data-watcher is not running")
+		os.Exit(statusNotRunningExitCode)
+	}
+
+	fmt.Printf("s3-data-watcher is running (pid %d)\n", pid)
+	return nil
+}
+
+func processRestartCommand(command *cobra.Command, args []string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "processRestartCommand",
+	})
+
+	config, err := cmd_commons.ReadConfigFromFlags(command)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	err = stopRunningInstance(config)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	// start again
+	parentMain(command, args)
+	return nil
+}
+
+// stopRunningInstance sends SIGTERM to the running instance and waits until it exits
+func stopRunningInstance(config *commons.Config) error {
+	pidFilePath := config.GetPIDFilePath()
+
+	pid, running := cmd_commons.GetRunningPID(pidFilePath)
+	if !running {
+		if pid > 0 {
+			// stale pid file
+			os.Remove(pidFilePath)
+		}
+
+		fmt.Println("s3-data-watcher is not running")
+		return nil
+	}
+
+	fmt.Printf("stopping s3-data-watcher (pid %d)\n", pid)
+
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		return xerrors.Errorf("failed to send signal to process %d: %w", pid, err)
+	}
+
+	// running jobs are drained before exit
+	stopTimeout := config.GetDrainTimeout() + commons.JobKillGracePeriod + stopTimeoutMargin
+
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		if !cmd_commons.IsProcessRunning(pid) {
+			fmt.Println("s3-data-watcher stopped")
+			return nil
+		}
+
+		time.Sleep(stopCheckInterval)
+	}
+
+	return xerrors.Errorf("s3-data-watcher (pid %d) did not stop in %f seconds", pid, stopTimeout.Seconds())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	cmd_commons "github.com/cyverse/s3-data-watcher/cmd/commons"
+	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/spf13/cobra"
+)
+
+// readConfigIn reads the config file as a control command run in the dir
+func readConfigIn(t *testing.T, dirPath string, configPath string) *commons.Config {
+	t.Helper()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working dir: %v", err)
+	}
+
+	err = os.Chdir(dirPath)
+	if err != nil {
+		t.Fatalf("failed to change working dir: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	command := &cobra.Command{}
+	cmd_commons.SetControlFlags(command)
+	err = command.Flags().Set("config", configPath)
+	if err != nil {
+		t.Fatalf("failed to set config flag: %v", err)
+	}
+
+	config, err := cmd_commons.ReadConfigFromFlags(command)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+
+	return config
+}
+
+func TestStopFromOtherWorkingDir(t *testing.T) {
+	tests := []struct {
+		name       string
+		configYAML string
+	}{
+		{"default data root dir", "drain_timeout: 0\n"},
+		{"relative data root dir", "data_root_path: data\ndrain_timeout: 0\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configDirPath := t.TempDir()
+			configPath := filepath.Join(configDirPath, "config.yaml")
+			err := os.WriteFile(configPath, []byte(test.configYAML), 0644)
+			if err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+
+			// started in the config dir
+			startConfig := readConfigIn(t, configDirPath, configPath)
+
+			err = os.MkdirAll(startConfig.DataRootPath, 0775)
+			if err != nil {
+				t.Fatalf("failed to make data root dir: %v", err)
+			}
+
+			process := exec.Command("sleep", "30")
+			err = process.Start()
+			if err != nil {
+				t.Fatalf("failed to start process: %v", err)
+			}
+
+			exited := make(chan bool)
+			go func() {
+				process.Wait()
+				close(exited)
+			}()
+			defer process.Process.Kill()
+
+			err = os.WriteFile(startConfig.GetPIDFilePath(), []byte(strconv.Itoa(process.Process.Pid)), 0644)
+			if err != nil {
+				t.Fatalf("failed to write pid file: %v", err)
+			}
+
+			// stopped in another dir
+			stopConfig := readConfigIn(t, t.TempDir(), configPath)
+			if stopConfig.GetPIDFilePath() != startConfig.GetPIDFilePath() {
+				t.Fatalf("pid file %s differs from %s", stopConfig.GetPIDFilePath(), startConfig.GetPIDFilePath())
+			}
+
+			err = stopRunningInstance(stopConfig)
+			if err != nil {
+				t.Fatalf("failed to stop: %v", err)
+			}
+
+			select {
+			case <-exited:
+			case <-time.After(5 * time.Second):
+				t.Fatal("process is not stopped")
+			}
+		})
+	}
+}
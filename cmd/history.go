@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	cmd_commons "github.com/cyverse/s3-data-watcher/cmd/commons"
+	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/cyverse/s3-data-watcher/service"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+const (
+	historyRequestTimeout time.Duration = 30 * time.Second
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show job run history",
+	Long:  "Show job run history, newest first.",
+	RunE:  processHistoryCommand,
+}
+
+func setHistoryFlags(command *cobra.Command) {
+	cmd_commons.SetControlFlags(command)
+
+	command.Flags().String("job", "", "Filter by job")
+	command.Flags().String("bucket", "", "Filter by bucket")
+	command.Flags().String("prefix", "", "Filter by object key prefix")
+	command.Flags().String("status", "", "Filter by status (succeeded, skipped, failed, timed_out)")
+	command.Flags().String("since", "", "Show runs started since the time (RFC3339) or the duration ago (e.g. 24h)")
+	command.Flags().String("until", "", "Show runs started until the time (RFC3339) or the duration ago (e.g. 1h)")
+	command.Flags().Int("limit", service.HistoryQueryLimitDefault, "Max number of runs to show, 0 for no limit")
+	command.Flags().Bool("json", false, "Print in JSON")
+}
+
+func processHistoryCommand(command *cobra.Command, args []string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "processHistoryCommand",
+	})
+
+	config, err := cmd_commons.ReadConfigFromFlags(command)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	query, err := getHistoryQueryFromFlags(command)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	records, err := queryHistory(config, query)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	printJSON, _ := command.Flags().GetBool("json")
+	if printJSON {
+		recordsBytes, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(recordsBytes))
+		return nil
+	}
+
+	printHistory(records)
+	return nil
+}
+
+func getHistoryQueryFromFlags(command *cobra.Command) (*service.JobRunQuery, error) {
+	query := &service.JobRunQuery{}
+
+	query.Job, _ = command.Flags().GetString("job")
+	query.Bucket, _ = command.Flags().GetString("bucket")
+	query.KeyPrefix, _ = command.Flags().GetString("prefix")
+	query.Status, _ = command.Flags().GetString("status")
+	query.Limit, _ = command.Flags().GetInt("limit")
+
+	since, _ := command.Flags().GetString("since")
+	if len(since) > 0 {
+		sinceTime, err := parseHistoryTime(since)
+		if err != nil {
+			return nil, err
+		}
+		query.Since = sinceTime
+	}
+
+	until, _ := command.Flags().GetString("until")
+	if len(until) > 0 {
+		untilTime, err := parseHistoryTime(until)
+		if err != nil {
+			return nil, err
+		}
+		query.Until = untilTime
+	}
+
+	return query, nil
+}
+
+// parseHistoryTime parses RFC3339 time or duration before now
+func parseHistoryTime(value string) (time.Time, error) {
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	timeValue, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("failed to parse time %q, must be RFC3339 or duration", value)
+	}
+
+	return timeValue, nil
+}
+
+// queryHistory queries the running service via admin API or reads the history file directly
+func queryHistory(config *commons.Config, query *service.JobRunQuery) ([]*service.JobRunRecord, error) {
+	_, running := cmd_commons.GetRunningPID(config.GetPIDFilePath())
+	if !running {
+		return service.QueryHistoryFile(config.GetHistoryFilePath(), query)
+	}
+
+	if len(config.AdminListenAddress) == 0 {
+		return nil, xerrors.Errorf("s3-data-watcher is running, set admin_listen_address to query history while running")
+	}
+
+	return queryHistoryAPI(config.AdminListenAddress, config.AdminToken, query)
+}
+
+func queryHistoryAPI(listenAddress string, adminToken string, query *service.JobRunQuery) ([]*service.JobRunRecord, error) {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse admin listen address %q: %w", listenAddress, err)
+	}
+
+	// listening on all interfaces
+	if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	apiURL := url.URL{
+		Scheme:   "http",
+		Host:     net.JoinHostPort(host, port),
+		Path:     service.HistoryAPIPath,
+		RawQuery: query.Values().Encode(),
+	}
+
+	request, err := http.NewRequest(http.MethodGet, apiURL.String(), nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create history request: %w", err)
+	}
+
+	if len(adminToken) > 0 {
+		request.Header.Set("Authorization", "Bearer "+adminToken)
+	}
+
+	client := http.Client{
+		Timeout: historyRequestTimeout,
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to query history: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("failed to query history: %s", response.Status)
+	}
+
+	records := []*service.JobRunRecord{}
+	err = json.NewDecoder(response.Body).Decode(&records)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode history: %w", err)
+	}
+
+	return records, nil
+}
+
+func printHistory(records []*service.JobRunRecord) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "START TIME\tJOB\tSTATUS\tEXIT\tATTEMPTS\tDURATION\tBUCKET\tKEY\tRUN ID")
+
+	for _, record := range records {
+		duration := record.EndTime.Sub(record.StartTime).Round(time.Millisecond)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			record.StartTime.Local().Format(time.RFC3339),
+			record.Job,
+			record.Status,
+			record.ExitCode,
+			record.Attempts,
+			duration.String(),
+			record.Bucket,
+			record.Key,
+			record.RunID,
+		)
+	}
+
+	writer.Flush()
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"sync"
+	"syscall"
 
 	cmd_commons "github.com/cyverse/s3-data-watcher/cmd/commons"
 	"github.com/cyverse/s3-data-watcher/commons"
@@ -52,11 +52,7 @@ func processCommand(command *cobra.Command, args []string) error {
 }
 
 func main() {
-	log.SetFormatter(&log.TextFormatter{
-		TimestampFormat: "2006-01-02 15:04:05.000000",
-		FullTimestamp:   true,
-	})
-
+	cmd_commons.SetLogFormatter(commons.LogFormatText)
 	log.SetLevel(log.InfoLevel)
 
 	logger := log.WithFields(log.Fields{
@@ -67,6 +63,16 @@ func main() {
 	// attach common flags
 	cmd_commons.SetCommonFlags(rootCmd)
 
+	// attach daemon control commands
+	cmd_commons.SetControlFlags(stopCmd)
+	cmd_commons.SetControlFlags(statusCmd)
+	cmd_commons.SetCommonFlags(restartCmd)
+
+	rootCmd.AddCommand(stopCmd, statusCmd, restartCmd)
+
+	setHistoryFlags(historyCmd)
+	rootCmd.AddCommand(historyCmd)
+
 	err := Execute()
 	if err != nil {
 		logger.Fatal(err)
@@ -97,6 +103,12 @@ func parentMain(command *cobra.Command, args []string) {
 
 	if !config.Foreground {
 		// background
+		pid, running := cmd_commons.GetRunningPID(config.GetPIDFilePath())
+		if running {
+			logger.Errorf("s3-data-watcher is already running (pid %d)", pid)
+			os.Exit(1)
+		}
+
 		childStdin, childStdout, err := cmd_commons.RunChildProcess(os.Args[0])
 		if err != nil {
 			logger.WithError(err).Error("failed to run s3-data-watcher child process")
@@ -162,26 +174,50 @@ func run(config *commons.Config, isChildProcess bool) error {
 		"function": "run",
 	})
 
-	if config.Debug {
-		log.SetLevel(log.DebugLevel)
+	err := cmd_commons.ConfigureLogger(config)
+	if err != nil {
+		logger.WithError(err).Error("invalid configuration")
+		if isChildProcess {
+			cmd_commons.ReportChildProcessError()
+		}
+		return err
 	}
 
 	versionInfo := commons.GetVersion()
 	logger.Infof("s3-data-watcher version - %s, commit - %s", versionInfo.ReleaseVersion, versionInfo.GitCommit)
 
 	// make work dirs required
-	err := config.MakeWorkDirs()
+	err = config.MakeWorkDirs()
 	if err != nil {
 		logger.WithError(err).Error("invalid configuration")
+		if isChildProcess {
+			cmd_commons.ReportChildProcessError()
+		}
 		return err
 	}
 
 	err = config.Validate()
 	if err != nil {
 		logger.WithError(err).Error("invalid configuration")
+		if isChildProcess {
+			cmd_commons.ReportChildProcessError()
+		}
+		return err
+	}
+
+	// refuse to run multiple instances
+	pidFilePath := config.GetPIDFilePath()
+	err = cmd_commons.WritePIDFile(pidFilePath)
+	if err != nil {
+		logger.WithError(err).Error("failed to write pid file")
+		if isChildProcess {
+			cmd_commons.ReportChildProcessError()
+		}
 		return err
 	}
 
+	defer cmd_commons.RemovePIDFile(pidFilePath)
+
 	// run a service
 	svc, err := service.NewService(config)
 	if err != nil {
@@ -202,23 +238,28 @@ func run(config *commons.Config, isChildProcess bool) error {
 	defer svc.Release()
 
 	// wait
-	waitForCtrlC()
+	waitForTerminationSignal()
 
 	return nil
 }
 
-func waitForCtrlC() {
-	var endWaiter sync.WaitGroup
+// waitForTerminationSignal waits for SIGINT or SIGTERM
+// a second signal received during shutdown forces an immediate exit
+func waitForTerminationSignal() {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "waitForTerminationSignal",
+	})
 
-	endWaiter.Add(1)
 	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 
-	signal.Notify(signalChannel, os.Interrupt)
+	sig := <-signalChannel
+	logger.Infof("received %s, shutting down", sig)
 
 	go func() {
-		<-signalChannel
-		endWaiter.Done()
+		sig := <-signalChannel
+		logger.Warnf("received %s again, exiting immediately", sig)
+		os.Exit(1)
 	}()
-
-	endWaiter.Wait()
 }
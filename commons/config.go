@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
 	"gopkg.in/yaml.v2"
 )
@@ -18,8 +19,51 @@ const (
 	NatsMaxReconnectsDefault  int    = -1
 	NatsReconnectWaitDefault  int    = -1
 	NatsRequestTimeoutDefault int    = -1
-
-	ReconnectInterval time.Duration = 1 * time.Minute
+	DrainTimeoutDefault       int    = 30
+	HistoryRetentionDefault   int    = 30
+	JobLogRetentionDefault    int    = 30
+	DedupTTLDefault           int    = 0 // disabled, re-uploads of identical content would be dropped
+
+	LogFormatText   string = "text"
+	LogFormatJSON   string = "json"
+	LogFormatLogfmt string = "logfmt"
+
+	LogOutputFile     string = "file"
+	LogOutputSyslog   string = "syslog"
+	LogOutputJournald string = "journald"
+
+	LogRotationMaxSizeDefault    int    = 50 // 50MB
+	LogRotationMaxBackupsDefault int    = 5
+	LogRotationMaxAgeDefault     int    = 30 // 30 days
+	LogTagDefault                string = "s3-data-watcher"
+
+	TracingExporterNone       string  = "none"
+	TracingExporterOTLP       string  = "otlp"
+	TracingExporterStdout     string  = "stdout"
+	TracingServiceNameDefault string  = "s3-data-watcher"
+	TracingSampleRatioDefault float64 = 1.0
+
+	ReconnectInterval           time.Duration = 1 * time.Minute
+	JobKillGracePeriod          time.Duration = 5 * time.Second
+	SystemdStatusUpdateInterval time.Duration = 10 * time.Second
+	DispatcherStallTimeout      time.Duration = 5 * time.Minute
+
+	JobOutputMaxSize        int = 4 * 1024  // 4KB
+	JobResultMaxSize        int = 64 * 1024 // 64KB, stdout parsed as the result of a run
+	JobChainMaxDepth        int = 32
+	DebounceMaxPending      int = 10000
+	DatasetMaxPending       int = 10000
+	OrderingMaxIdle         int = 100000 // idle partitions kept
+	CircuitBreakerMaxParked int = 10000  // runs parked per job
+
+	CircuitBreakerCooldownDefault int = 60 // 1 minute
+	CircuitBreakerReleaseRate     int = 10 // parked runs started per second once the breaker closes
+
+	OrderingSequencerTTLDefault int = 86400 // 1 day
+	DatasetMaxAgeDefault        int = 86400 // 1 day
+
+	CompletionEventMaxRecords int = 100  // records of a batch or dataset run sent in a completion event
+	CompletionQueueSize       int = 1000 // completion events waiting to be published
 )
 
 // NatsConfig is a configuration struct for Nats Message bus
@@ -29,12 +73,93 @@ type NatsConfig struct {
 	MaxReconnects  int    `yaml:"max_reconnects,omitempty"`
 	ReconnectWait  int    `yaml:"reconnect_wait,omitempty"`
 	RequestTimeout int    `yaml:"request_timeout,omitempty"`
+	// Go template of the subject to publish job completion events, empty = no completion events
+	CompletionSubject string `yaml:"completion_subject,omitempty"`
+}
+
+// TracingConfig is a configuration struct for OpenTelemetry tracing
+type TracingConfig struct {
+	// none, otlp or stdout
+	Exporter string `yaml:"exporter,omitempty"`
+	// OTLP/HTTP endpoint (host:port), use OTEL_EXPORTER_OTLP_ENDPOINT if empty
+	Endpoint    string  `yaml:"endpoint,omitempty"`
+	Insecure    bool    `yaml:"insecure,omitempty"`
+	ServiceName string  `yaml:"service_name,omitempty"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// LogRotationConfig is a configuration struct for log file rotation
+type LogRotationConfig struct {
+	// megabytes
+	MaxSize int `yaml:"max_size"`
+	// 0 to keep all
+	MaxBackups int `yaml:"max_backups"`
+	// days, 0 to keep forever
+	MaxAge   int  `yaml:"max_age"`
+	Compress bool `yaml:"compress"`
+}
+
+// RateLimitConfig is a configuration struct for token-bucket rate limit
+type RateLimitConfig struct {
+	// runs per second
+	Rate float64 `yaml:"rate"`
+	// runs allowed at once, 0 = 1
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// GetBurst returns burst of the rate limit
+func (rateLimit *RateLimitConfig) GetBurst() int {
+	if rateLimit.Burst < 1 {
+		return 1
+	}
+	return rateLimit.Burst
+}
+
+// Validate validates the rate limit
+func (rateLimit *RateLimitConfig) Validate() error {
+	if rateLimit.Rate <= 0 {
+		return xerrors.Errorf("rate must be positive")
+	}
+
+	if rateLimit.Burst < 0 {
+		return xerrors.Errorf("burst must not be negative")
+	}
+
+	return nil
+}
+
+// SyslogConfig is a configuration struct for syslog output
+type SyslogConfig struct {
+	// empty for local syslog, udp, tcp, unix or unixgram
+	Network string `yaml:"network,omitempty"`
+	// host:port for udp and tcp, socket path for unix and unixgram
+	Address string `yaml:"address,omitempty"`
 }
 
 func getLogFilename() string {
 	return "s3_data_watcher.log"
 }
 
+func getPIDFilename() string {
+	return "s3_data_watcher.pid"
+}
+
+func getHistoryFilename() string {
+	return "history.db"
+}
+
+func getDedupFilename() string {
+	return "dedup.db"
+}
+
+func getSpoolDirname() string {
+	return "spool"
+}
+
+func getJobLogDirname() string {
+	return "jobs"
+}
+
 func GetDefaultDataRootDirPath() string {
 	dirPath, err := os.Getwd()
 	if err != nil {
@@ -51,9 +176,45 @@ type Config struct {
 	NatsConfig NatsConfig `yaml:"nats_config,omitempty"`
 
 	JobFilePath string `yaml:"job_file_path,omitempty"`
+	// dir having job files (*.yaml, *.yml), or glob pattern of job files, to merge with the job file
+	JobDir string `yaml:"job_dir,omitempty"`
+
+	TracingConfig TracingConfig `yaml:"tracing_config,omitempty"`
+
+	// seconds to wait for running jobs on shutdown
+	DrainTimeout int `yaml:"drain_timeout"`
+
+	// days to keep job run history, 0 to keep forever
+	HistoryRetention int `yaml:"history_retention"`
+
+	// days to keep job output log files, 0 to keep forever
+	JobLogRetention int `yaml:"job_log_retention"`
+
+	// seconds to remember events to suppress duplicates, 0 to disable (default)
+	DedupTTL int `yaml:"dedup_ttl"`
+
+	// rate limits of job runs per bucket across all jobs, key: bucket name
+	BucketRateLimits map[string]RateLimitConfig `yaml:"bucket_rate_limits,omitempty"`
+
+	// for HTTP endpoints (metrics, health), empty to disable
+	HTTPListenAddress string `yaml:"http_listen_address,omitempty"`
+
+	// for admin APIs (history, circuit breakers), empty to disable
+	AdminListenAddress string `yaml:"admin_listen_address,omitempty"`
+	// bearer token required by admin APIs, empty to allow all clients that can reach the admin listen address
+	AdminToken string `yaml:"admin_token,omitempty"`
 
 	// for Logging
 	LogPath string `yaml:"log_path,omitempty"`
+	// text, json or logfmt
+	LogFormat string `yaml:"log_format,omitempty"`
+	// trace, debug, info, warn or error, debug overrides if lower
+	LogLevel string `yaml:"log_level,omitempty"`
+	// file, syslog or journald
+	LogOutput   string            `yaml:"log_output,omitempty"`
+	LogTag      string            `yaml:"log_tag,omitempty"`
+	LogRotation LogRotationConfig `yaml:"log_rotation,omitempty"`
+	Syslog      SyslogConfig      `yaml:"syslog,omitempty"`
 
 	Foreground   bool `yaml:"foreground,omitempty"`
 	Debug        bool `yaml:"debug,omitempty"`
@@ -65,6 +226,17 @@ func NewDefaultConfig() *Config {
 	return &Config{
 		DataRootPath: GetDefaultDataRootDirPath(),
 		JobFilePath:  JobFilePathDefault,
+		DrainTimeout: DrainTimeoutDefault,
+
+		HistoryRetention: HistoryRetentionDefault,
+		JobLogRetention:  JobLogRetentionDefault,
+		DedupTTL:         DedupTTLDefault,
+
+		TracingConfig: TracingConfig{
+			Exporter:    TracingExporterNone,
+			ServiceName: TracingServiceNameDefault,
+			SampleRatio: TracingSampleRatioDefault,
+		},
 
 		NatsConfig: NatsConfig{
 			URL:            NatsUrlDefault,
@@ -74,7 +246,21 @@ func NewDefaultConfig() *Config {
 			RequestTimeout: NatsRequestTimeoutDefault,
 		},
 
-		LogPath: "", // use default
+		HTTPListenAddress:  "", // disabled
+		AdminListenAddress: "", // disabled
+		AdminToken:         "",
+
+		LogPath:   "", // use default
+		LogFormat: LogFormatText,
+		LogLevel:  "", // info, or debug if debug is set
+		LogOutput: LogOutputFile,
+		LogTag:    LogTagDefault,
+		LogRotation: LogRotationConfig{
+			MaxSize:    LogRotationMaxSizeDefault,
+			MaxBackups: LogRotationMaxBackupsDefault,
+			MaxAge:     LogRotationMaxAgeDefault,
+			Compress:   false,
+		},
 
 		Foreground:   false,
 		Debug:        false,
@@ -94,6 +280,41 @@ func NewConfigFromYAML(yamlBytes []byte) (*Config, error) {
 	return config, nil
 }
 
+// NewConfigFromYAMLFile creates Config from a YAML file
+// data root dir is resolved relative to the dir of the file, and defaults to the dir,
+// so commands given the same config file find the same PID file wherever they run
+func NewConfigFromYAMLFile(configPath string) (*Config, error) {
+	yamlBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+
+	config := NewDefaultConfig()
+	config.DataRootPath = "" // to tell if given
+
+	err = yaml.Unmarshal(yamlBytes, config)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal YAML - %v", err)
+	}
+
+	configDirPath, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get absolute path of config file %s: %w", configPath, err)
+	}
+
+	dataRootPath, err := ExpandHomeDir(config.DataRootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(dataRootPath) {
+		dataRootPath = filepath.Join(configDirPath, dataRootPath)
+	}
+
+	config.DataRootPath = dataRootPath
+	return config, nil
+}
+
 // GetLogFilePath returns log file path
 func (config *Config) GetLogFilePath() string {
 	if len(config.LogPath) > 0 {
@@ -104,6 +325,74 @@ func (config *Config) GetLogFilePath() string {
 	return path.Join(config.DataRootPath, getLogFilename())
 }
 
+// GetDrainTimeout returns drain timeout
+func (config *Config) GetDrainTimeout() time.Duration {
+	return time.Duration(config.DrainTimeout) * time.Second
+}
+
+// GetHistoryRetention returns how long job run history is kept
+func (config *Config) GetHistoryRetention() time.Duration {
+	return time.Duration(config.HistoryRetention) * 24 * time.Hour
+}
+
+// GetHistoryFilePath returns job run history file path
+func (config *Config) GetHistoryFilePath() string {
+	return path.Join(config.DataRootPath, getHistoryFilename())
+}
+
+// GetDedupTTL returns how long events are remembered to suppress duplicates
+func (config *Config) GetDedupTTL() time.Duration {
+	return time.Duration(config.DedupTTL) * time.Second
+}
+
+// GetDedupFilePath returns dedup store file path
+func (config *Config) GetDedupFilePath() string {
+	return path.Join(config.DataRootPath, getDedupFilename())
+}
+
+// GetSpoolDirPath returns the dir path having events waiting for jobs to finish
+func (config *Config) GetSpoolDirPath() string {
+	return path.Join(config.DataRootPath, getSpoolDirname())
+}
+
+// GetJobLogRetention returns how long job output log files are kept
+func (config *Config) GetJobLogRetention() time.Duration {
+	return time.Duration(config.JobLogRetention) * 24 * time.Hour
+}
+
+// GetJobLogDirPath returns the dir path having job output log files
+func (config *Config) GetJobLogDirPath() string {
+	return path.Join(config.DataRootPath, getJobLogDirname())
+}
+
+// GetPIDFilePath returns PID file path
+func (config *Config) GetPIDFilePath() string {
+	return path.Join(config.DataRootPath, getPIDFilename())
+}
+
+// GetLogLevel returns log level
+func (config *Config) GetLogLevel() (log.Level, error) {
+	level := log.InfoLevel
+	if len(config.LogLevel) > 0 {
+		parsedLevel, err := log.ParseLevel(config.LogLevel)
+		if err != nil {
+			return level, xerrors.Errorf("failed to parse log level %q: %w", config.LogLevel, err)
+		}
+
+		if parsedLevel < log.ErrorLevel {
+			return level, xerrors.Errorf("log level must be one of trace, debug, info, warn and error")
+		}
+
+		level = parsedLevel
+	}
+
+	if config.Debug && level < log.DebugLevel {
+		level = log.DebugLevel
+	}
+
+	return level, nil
+}
+
 // MakeLogDir makes a log dir required
 func (config *Config) MakeLogDir() error {
 	logFilePath := config.GetLogFilePath()
@@ -164,8 +453,88 @@ func (config *Config) Validate() error {
 		return xerrors.Errorf("data root dir must be given")
 	}
 
-	if len(config.JobFilePath) == 0 {
-		return xerrors.Errorf("job file path must be given")
+	if len(config.JobFilePath) == 0 && len(config.JobDir) == 0 {
+		return xerrors.Errorf("job file path or job dir must be given")
+	}
+
+	// job dir may be a glob pattern
+	_, err := filepath.Match(config.JobDir, "")
+	if err != nil {
+		return xerrors.Errorf("invalid job dir pattern %q: %w", config.JobDir, err)
+	}
+
+	if config.DrainTimeout < 0 {
+		return xerrors.Errorf("drain timeout must not be negative")
+	}
+
+	// admin APIs must not be exposed with health checks
+	if len(config.AdminListenAddress) > 0 && config.AdminListenAddress == config.HTTPListenAddress {
+		return xerrors.Errorf("admin listen address must differ from http listen address")
+	}
+
+	switch config.TracingConfig.Exporter {
+	case "", TracingExporterNone, TracingExporterOTLP, TracingExporterStdout:
+	default:
+		return xerrors.Errorf("unknown tracing exporter %q", config.TracingConfig.Exporter)
+	}
+
+	// stdout of the background process is not kept after start
+	if config.TracingConfig.Exporter == TracingExporterStdout && !config.Foreground {
+		return xerrors.Errorf("tracing exporter %q requires running in foreground", TracingExporterStdout)
+	}
+
+	if config.TracingConfig.SampleRatio < 0 || config.TracingConfig.SampleRatio > 1 {
+		return xerrors.Errorf("tracing sample ratio must be between 0 and 1")
+	}
+
+	switch config.LogFormat {
+	case "", LogFormatText, LogFormatJSON, LogFormatLogfmt:
+	default:
+		return xerrors.Errorf("unknown log format %q", config.LogFormat)
+	}
+
+	_, err = config.GetLogLevel()
+	if err != nil {
+		return err
+	}
+
+	switch config.LogOutput {
+	case "", LogOutputFile, LogOutputSyslog, LogOutputJournald:
+	default:
+		return xerrors.Errorf("unknown log output %q", config.LogOutput)
+	}
+
+	switch config.Syslog.Network {
+	case "", "udp", "tcp", "unix", "unixgram":
+	default:
+		return xerrors.Errorf("unknown syslog network %q", config.Syslog.Network)
+	}
+
+	if config.LogRotation.MaxSize <= 0 {
+		return xerrors.Errorf("log rotation max size must be positive")
+	}
+
+	if config.LogRotation.MaxBackups < 0 || config.LogRotation.MaxAge < 0 {
+		return xerrors.Errorf("log rotation max backups and max age must not be negative")
+	}
+
+	if config.HistoryRetention < 0 {
+		return xerrors.Errorf("history retention must not be negative")
+	}
+
+	if config.DedupTTL < 0 {
+		return xerrors.Errorf("dedup ttl must not be negative")
+	}
+
+	for bucket, rateLimit := range config.BucketRateLimits {
+		err := rateLimit.Validate()
+		if err != nil {
+			return xerrors.Errorf("invalid rate limit of bucket %q: %w", bucket, err)
+		}
+	}
+
+	if config.JobLogRetention < 0 {
+		return xerrors.Errorf("job log retention must not be negative")
 	}
 
 	if len(config.NatsConfig.URL) == 0 {
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobBatch is records accumulated for a job run
+type jobBatch struct {
+	ctx            context.Context // of the first record
+	job            *Job
+	records        []events.S3EventRecord
+	encodedRecords [][]byte
+	size           int // bytes of encoded records
+	spoolEntries   []*spoolEntry
+	timer          *time.Timer
+}
+
+// payload returns records encoded in the batch format
+func (batch *jobBatch) payload() []byte {
+	buffer := bytes.Buffer{}
+
+	if batch.job.Batch.Format == JobBatchFormatNDJSON {
+		for _, encodedRecord := range batch.encodedRecords {
+			buffer.Write(encodedRecord)
+			buffer.WriteByte('\n')
+		}
+		return buffer.Bytes()
+	}
+
+	buffer.WriteByte('[')
+	for idx, encodedRecord := range batch.encodedRecords {
+		if idx > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(encodedRecord)
+	}
+	buffer.WriteByte(']')
+	return buffer.Bytes()
+}
+
+// batchJob adds the record to the job's batch, the batch runs the job when it is full or max wait passes
+func (externalCmdService *ExternalCmdService) batchJob(ctx context.Context, job *Job, record events.S3EventRecord) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "batchJob",
+	})
+
+	logger = logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, job.Name)
+
+	encodedRecord, err := json.Marshal(record)
+	if err != nil {
+		logger.WithError(err).Error("failed to marshal record")
+		getSpoolEntry(ctx).release()
+		return
+	}
+
+	batchConfig := job.Batch
+
+	externalCmdService.batchesLock.Lock()
+
+	if externalCmdService.batchClosed {
+		externalCmdService.batchesLock.Unlock()
+
+		logger.Warn("service is terminating, ignore job")
+		return
+	}
+
+	// batches to run after unlock
+	fullBatches := []*jobBatch{}
+
+	batch, ok := externalCmdService.batches[job.Name]
+	if ok && batchConfig.MaxBytes > 0 && batch.size+len(encodedRecord) > batchConfig.MaxBytes {
+		// no room for the record, flush first
+		externalCmdService.detachBatch(batch)
+		fullBatches = append(fullBatches, batch)
+		ok = false
+	}
+
+	if !ok {
+		batch = &jobBatch{
+			ctx:            ctx,
+			job:            job,
+			records:        []events.S3EventRecord{},
+			encodedRecords: [][]byte{},
+		}
+
+		batch.timer = time.AfterFunc(batchConfig.GetMaxWait(), func() {
+			externalCmdService.fireBatch(batch)
+		})
+
+		externalCmdService.batches[job.Name] = batch
+	}
+
+	// use the latest job definition
+	batch.job = job
+	batch.records = append(batch.records, record)
+	batch.encodedRecords = append(batch.encodedRecords, encodedRecord)
+	batch.size += len(encodedRecord)
+	if spoolEntry := getSpoolEntry(ctx); spoolEntry != nil {
+		batch.spoolEntries = append(batch.spoolEntries, spoolEntry)
+	}
+
+	batchRecords := len(batch.records)
+
+	if (batchConfig.MaxEvents > 0 && batchRecords >= batchConfig.MaxEvents) || (batchConfig.MaxBytes > 0 && batch.size >= batchConfig.MaxBytes) {
+		externalCmdService.detachBatch(batch)
+		fullBatches = append(fullBatches, batch)
+	}
+
+	externalCmdService.batchesLock.Unlock()
+
+	logger.Debugf("added a record to batch (%d records)", batchRecords)
+
+	for _, fullBatch := range fullBatches {
+		externalCmdService.runBatch(fullBatch, "full")
+	}
+}
+
+// detachBatch removes the batch so it is not flushed again, must be called with batchesLock
+func (externalCmdService *ExternalCmdService) detachBatch(batch *jobBatch) {
+	batch.timer.Stop()
+	if externalCmdService.batches[batch.job.Name] == batch {
+		delete(externalCmdService.batches, batch.job.Name)
+	}
+}
+
+// fireBatch runs the job when max wait passes
+func (externalCmdService *ExternalCmdService) fireBatch(batch *jobBatch) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "fireBatch",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	externalCmdService.batchesLock.Lock()
+	if externalCmdService.batches[batch.job.Name] != batch {
+		// flushed
+		externalCmdService.batchesLock.Unlock()
+		return
+	}
+
+	delete(externalCmdService.batches, batch.job.Name)
+	externalCmdService.batchesLock.Unlock()
+
+	externalCmdService.runBatch(batch, "max wait")
+}
+
+// runBatch runs the job with records in the batch
+func (externalCmdService *ExternalCmdService) runBatch(batch *jobBatch, reason string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "runBatch",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	logger.WithField(logFieldJob, batch.job.Name).Debugf("flushing batch of %d records (%d bytes) on %s", len(batch.records), batch.size, reason)
+
+	metricBatchSize.WithLabelValues(batch.job.Name).Observe(float64(len(batch.records)))
+
+	run := newJobRun(batch.job, batch.records, batch.payload())
+	run.spoolEntries = batch.spoolEntries
+
+	externalCmdService.startRun(batch.ctx, run)
+}
+
+// flushBatches runs jobs for all pending batches, used on shutdown
+func (externalCmdService *ExternalCmdService) flushBatches() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "flushBatches",
+	})
+
+	externalCmdService.batchesLock.Lock()
+	externalCmdService.batchClosed = true
+	batches := externalCmdService.batches
+	externalCmdService.batches = map[string]*jobBatch{}
+	externalCmdService.batchesLock.Unlock()
+
+	if len(batches) > 0 {
+		logger.Infof("flushing %d batches", len(batches))
+	}
+
+	for _, batch := range batches {
+		batch.timer.Stop()
+		externalCmdService.runBatch(batch, "shutdown")
+	}
+}
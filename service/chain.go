@@ -0,0 +1,188 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+const (
+	previousResultFileEnvName string = "PREVIOUS_RESULT_FILE"
+	previousRunIDEnvName      string = "PREVIOUS_RUN_ID"
+	previousJobEnvName        string = "PREVIOUS_JOB"
+	previousStatusEnvName     string = "PREVIOUS_STATUS"
+	previousExitCodeEnvName   string = "PREVIOUS_EXIT_CODE"
+
+	previousResultFilePattern string = "s3-data-watcher-previous-*.json"
+)
+
+// JobRunResult is the result of a run, passed to jobs chained after the run
+type JobRunResult struct {
+	RunID      string `json:"run_id"`
+	Job        string `json:"job"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exit_code"`
+	StatusCode int    `json:"status_code,omitempty"` // of http action
+	// outputs of the result reported by the job, or stdout parsed as JSON if no result is reported
+	Outputs interface{} `json:"outputs,omitempty"`
+	Message string      `json:"message,omitempty"` // of the result reported by the job
+	Error   string      `json:"error,omitempty"`
+}
+
+// newJobRunResult returns the result of the finished run
+func newJobRunResult(run *jobRun) *JobRunResult {
+	result := &JobRunResult{
+		RunID:      run.id,
+		Job:        run.job.Name,
+		Status:     run.getStatus(),
+		ExitCode:   run.exitCode,
+		StatusCode: run.statusCode,
+	}
+
+	if run.result != nil {
+		result.Message = run.result.Message
+		if len(run.result.Outputs) > 0 {
+			result.Outputs = run.result.Outputs
+		}
+	} else {
+		var outputs interface{}
+		err := json.Unmarshal([]byte(run.stdout.String()), &outputs)
+		if err == nil {
+			result.Outputs = outputs
+		}
+	}
+
+	if run.err != nil {
+		result.Error = run.err.Error()
+	}
+
+	return result
+}
+
+// getPreviousRunID returns the run id of the job chaining the run, empty if not chained
+func (run *jobRun) getPreviousRunID() string {
+	if run.previous == nil {
+		return ""
+	}
+	return run.previous.RunID
+}
+
+// createPreviousResultFile writes the result of the previous job to a temp file for commands
+// the result can be larger than an environment variable can hold, returns empty path if not chained
+func createPreviousResultFile(run *jobRun) (string, error) {
+	if run.previous == nil {
+		return "", nil
+	}
+
+	resultJson, err := json.Marshal(run.previous)
+	if err != nil {
+		return "", xerrors.Errorf("failed to marshal previous result: %w", err)
+	}
+
+	file, err := os.CreateTemp("", previousResultFilePattern)
+	if err != nil {
+		return "", xerrors.Errorf("failed to create previous result file: %w", err)
+	}
+
+	_, err = file.Write(resultJson)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", xerrors.Errorf("failed to write previous result file %s: %w", file.Name(), err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return "", xerrors.Errorf("failed to write previous result file %s: %w", file.Name(), err)
+	}
+
+	return file.Name(), nil
+}
+
+// getPreviousResultEnvs returns environment variables summarizing the result of the previous job for commands
+func getPreviousResultEnvs(run *jobRun, resultFilePath string) []string {
+	if run.previous == nil {
+		return []string{}
+	}
+
+	return []string{
+		previousResultFileEnvName + "=" + resultFilePath,
+		previousRunIDEnvName + "=" + run.previous.RunID,
+		previousJobEnvName + "=" + run.previous.Job,
+		previousStatusEnvName + "=" + run.previous.Status,
+		previousExitCodeEnvName + "=" + strconv.Itoa(run.previous.ExitCode),
+	}
+}
+
+// runChainedJobs runs jobs listed in on_success or on_failure of the finished run
+// chained runs get the records and the payload of the run, and hold the spooled events until they finish
+func (externalCmdService *ExternalCmdService) runChainedJobs(run *jobRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "runChainedJobs",
+	})
+
+	logger = logger.WithFields(getRunLogFields(run))
+
+	nextJobNames := []string{}
+	switch run.getStatus() {
+	case JobRunStatusSucceeded:
+		nextJobNames = run.job.OnSuccess
+	case JobRunStatusSkipped:
+		// nothing to pass
+	default:
+		nextJobNames = run.job.OnFailure
+	}
+
+	if len(nextJobNames) == 0 {
+		return
+	}
+
+	if run.chainDepth+1 >= commons.JobChainMaxDepth {
+		logger.Errorf("job chain is too deep (%d), stop chaining", commons.JobChainMaxDepth)
+		return
+	}
+
+	// use the latest job definitions
+	jobs, err := externalCmdService.readJobFile()
+	if err != nil {
+		logger.WithError(err).Error("failed to read job file, stop chaining")
+		return
+	}
+
+	result := newJobRunResult(run)
+
+	for _, nextJobName := range nextJobNames {
+		nextJob := jobs.GetJob(nextJobName)
+		if nextJob == nil {
+			logger.Errorf("chained job %q is not found", nextJobName)
+			continue
+		}
+
+		if !nextJob.IsEnabled() {
+			logger.Debugf("chained job %q is disabled, skip", nextJobName)
+			continue
+		}
+
+		nextRun := newJobRun(nextJob, run.records, run.payload)
+		nextRun.previous = result
+		nextRun.chainDepth = run.chainDepth + 1
+
+		for _, entry := range run.spoolEntries {
+			entry.acquire()
+			nextRun.addSpoolEntry(entry)
+		}
+
+		logger.Infof("chain job %q after %s run", nextJobName, result.Status)
+		metricJobsChained.WithLabelValues(nextJob.Name).Inc()
+
+		// continue the trace of the run
+		externalCmdService.startRun(run.ctx, nextRun)
+	}
+}
@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+const (
+	CircuitBreakerStateClosed   string = "closed"
+	CircuitBreakerStateOpen     string = "open"
+	CircuitBreakerStateHalfOpen string = "half_open"
+
+	eventDropReasonCircuitOpen string = "circuit_open"
+)
+
+// circuitBreaker tracks consecutive failures of a job
+// closed: runs start as usual
+// open: runs are parked until the cooldown passes
+// half_open: a probe run is running or about to run, other runs are parked
+type circuitBreaker struct {
+	jobName    string
+	state      string
+	failures   int // consecutive
+	openedTime time.Time
+	probeTime  time.Time // when the cooldown passes
+	probeRunID string    // empty if no probe is running
+	parked     []*pendingRun
+	timer      *time.Timer
+}
+
+// CircuitBreakerStatus is the state of a job's circuit breaker, returned by admin API
+type CircuitBreakerStatus struct {
+	Job                 string     `json:"job"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Parked              int        `json:"parked"`
+	OpenedTime          *time.Time `json:"opened_time,omitempty"`
+	ProbeTime           *time.Time `json:"probe_time,omitempty"`
+	ProbeRunID          string     `json:"probe_run_id,omitempty"`
+}
+
+func (breaker *circuitBreaker) getStatus() CircuitBreakerStatus {
+	status := CircuitBreakerStatus{
+		Job:                 breaker.jobName,
+		State:               breaker.state,
+		ConsecutiveFailures: breaker.failures,
+		Parked:              len(breaker.parked),
+		ProbeRunID:          breaker.probeRunID,
+	}
+
+	if breaker.state != CircuitBreakerStateClosed {
+		openedTime := breaker.openedTime
+		probeTime := breaker.probeTime
+		status.OpenedTime = &openedTime
+		status.ProbeTime = &probeTime
+	}
+
+	return status
+}
+
+// setState updates the state and its metrics, must be called with circuitBreakersLock
+func (breaker *circuitBreaker) setState(state string) {
+	breaker.state = state
+
+	for _, s := range []string{CircuitBreakerStateClosed, CircuitBreakerStateOpen, CircuitBreakerStateHalfOpen} {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		metricCircuitBreakerState.WithLabelValues(breaker.jobName, s).Set(value)
+	}
+}
+
+// GetCircuitBreakers returns states of circuit breakers of jobs, sorted by job name
+func (externalCmdService *ExternalCmdService) GetCircuitBreakers() []CircuitBreakerStatus {
+	externalCmdService.circuitBreakersLock.Lock()
+	defer externalCmdService.circuitBreakersLock.Unlock()
+
+	statuses := make([]CircuitBreakerStatus, 0, len(externalCmdService.circuitBreakers))
+	for _, breaker := range externalCmdService.circuitBreakers {
+		statuses = append(statuses, breaker.getStatus())
+	}
+
+	sort.Slice(statuses, func(i int, j int) bool {
+		return statuses[i].Job < statuses[j].Job
+	})
+
+	return statuses
+}
+
+// admitRun returns true if the run can start, otherwise the run is parked
+// returns an error if the run can neither start nor be parked
+func (externalCmdService *ExternalCmdService) admitRun(ctx context.Context, run *jobRun) (bool, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "admitRun",
+	})
+
+	job := run.job
+
+	externalCmdService.circuitBreakersLock.Lock()
+
+	breaker, ok := externalCmdService.circuitBreakers[job.Name]
+	if job.CircuitBreaker == nil {
+		if !ok {
+			externalCmdService.circuitBreakersLock.Unlock()
+			return true, nil
+		}
+
+		// the breaker is removed from the job, run parked ones
+		parked := externalCmdService.removeCircuitBreaker(breaker)
+		externalCmdService.circuitBreakersLock.Unlock()
+
+		externalCmdService.releaseParkedRuns(nil, parked)
+		return true, nil
+	}
+
+	if !ok {
+		breaker = &circuitBreaker{
+			jobName: job.Name,
+			parked:  []*pendingRun{},
+		}
+		breaker.setState(CircuitBreakerStateClosed)
+		externalCmdService.circuitBreakers[job.Name] = breaker
+	}
+
+	switch breaker.state {
+	case CircuitBreakerStateClosed:
+		externalCmdService.circuitBreakersLock.Unlock()
+		return true, nil
+	case CircuitBreakerStateHalfOpen:
+		if len(breaker.probeRunID) == 0 {
+			breaker.probeRunID = run.id
+			externalCmdService.circuitBreakersLock.Unlock()
+
+			logger.WithFields(getRunLogFields(run)).Info("run a probe of the open circuit breaker")
+			return true, nil
+		}
+	}
+
+	if len(breaker.parked) >= commons.CircuitBreakerMaxParked {
+		externalCmdService.circuitBreakersLock.Unlock()
+
+		// spooled events are kept to replay
+		metricEventsDropped.WithLabelValues(job.Name, eventDropReasonCircuitOpen).Inc()
+		return false, xerrors.Errorf("too many runs parked by the open circuit breaker (%d), drop the run", commons.CircuitBreakerMaxParked)
+	}
+
+	breaker.parked = append(breaker.parked, &pendingRun{
+		ctx: ctx,
+		run: run,
+	})
+	parked := len(breaker.parked)
+	externalCmdService.circuitBreakersLock.Unlock()
+
+	metricRunsParked.WithLabelValues(job.Name).Inc()
+	logger.WithFields(getRunLogFields(run)).Infof("circuit breaker is %s, park the run (%d parked)", breaker.state, parked)
+	return false, nil
+}
+
+// recordRunResult updates the circuit breaker of the job with the result of the finished run
+func (externalCmdService *ExternalCmdService) recordRunResult(run *jobRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "recordRunResult",
+	})
+
+	logger = logger.WithField(logFieldJob, run.job.Name)
+
+	if run.attempts == 0 {
+		// never ran, the next run becomes the probe
+		externalCmdService.circuitBreakersLock.Lock()
+		breaker, ok := externalCmdService.circuitBreakers[run.job.Name]
+		isProbe := ok && len(breaker.probeRunID) > 0 && breaker.probeRunID == run.id
+		if isProbe {
+			breaker.probeRunID = ""
+		}
+		externalCmdService.circuitBreakersLock.Unlock()
+
+		if isProbe {
+			logger.Info("probe run did not run, try another")
+			externalCmdService.runParkedProbe(breaker)
+		}
+		return
+	}
+
+	status := run.getStatus()
+	succeeded := status == JobRunStatusSucceeded || status == JobRunStatusSkipped
+
+	externalCmdService.circuitBreakersLock.Lock()
+
+	breaker, ok := externalCmdService.circuitBreakers[run.job.Name]
+	if !ok || run.job.CircuitBreaker == nil {
+		externalCmdService.circuitBreakersLock.Unlock()
+		return
+	}
+
+	isProbe := breaker.probeRunID == run.id
+
+	if succeeded {
+		breaker.failures = 0
+
+		if breaker.state == CircuitBreakerStateClosed || !isProbe {
+			externalCmdService.circuitBreakersLock.Unlock()
+			return
+		}
+
+		parked := breaker.parked
+		breaker.parked = []*pendingRun{}
+		breaker.probeRunID = ""
+		breaker.setState(CircuitBreakerStateClosed)
+		externalCmdService.circuitBreakersLock.Unlock()
+
+		logger.Infof("probe run succeeded, close the circuit breaker and release %d parked runs", len(parked))
+		externalCmdService.releaseParkedRuns(breaker, parked)
+		return
+	}
+
+	breaker.failures++
+
+	switch breaker.state {
+	case CircuitBreakerStateClosed:
+		if breaker.failures < run.job.CircuitBreaker.Threshold {
+			externalCmdService.circuitBreakersLock.Unlock()
+			return
+		}
+
+		logger.Warnf("job failed %d times in a row, open the circuit breaker for %f seconds", breaker.failures, run.job.CircuitBreaker.GetCooldown().Seconds())
+	case CircuitBreakerStateHalfOpen:
+		if !isProbe {
+			externalCmdService.circuitBreakersLock.Unlock()
+			return
+		}
+
+		logger.Warnf("probe run failed, keep the circuit breaker open for %f seconds", run.job.CircuitBreaker.GetCooldown().Seconds())
+	default:
+		// runs started before the breaker opened
+		externalCmdService.circuitBreakersLock.Unlock()
+		return
+	}
+
+	externalCmdService.openCircuitBreaker(breaker, run.job.CircuitBreaker.GetCooldown())
+	externalCmdService.circuitBreakersLock.Unlock()
+}
+
+// openCircuitBreaker opens the breaker and schedules a probe, must be called with circuitBreakersLock
+func (externalCmdService *ExternalCmdService) openCircuitBreaker(breaker *circuitBreaker, cooldown time.Duration) {
+	now := time.Now()
+
+	breaker.openedTime = now
+	breaker.probeTime = now.Add(cooldown)
+	breaker.probeRunID = ""
+	breaker.setState(CircuitBreakerStateOpen)
+
+	if breaker.timer != nil {
+		breaker.timer.Stop()
+	}
+
+	breaker.timer = time.AfterFunc(cooldown, func() {
+		externalCmdService.probeCircuitBreaker(breaker)
+	})
+}
+
+// probeCircuitBreaker starts the oldest parked run as a probe once the cooldown passes
+// if nothing is parked, the next run becomes the probe
+func (externalCmdService *ExternalCmdService) probeCircuitBreaker(breaker *circuitBreaker) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "probeCircuitBreaker",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	logger = logger.WithField(logFieldJob, breaker.jobName)
+
+	externalCmdService.circuitBreakersLock.Lock()
+	if externalCmdService.circuitBreakers[breaker.jobName] != breaker || breaker.state != CircuitBreakerStateOpen {
+		externalCmdService.circuitBreakersLock.Unlock()
+		return
+	}
+
+	breaker.setState(CircuitBreakerStateHalfOpen)
+	externalCmdService.circuitBreakersLock.Unlock()
+
+	logger.Info("cooldown passed, try a probe")
+	externalCmdService.runParkedProbe(breaker)
+}
+
+// runParkedProbe starts the oldest parked run as a probe of the half open breaker
+// if nothing is parked, the next run is a probe
+func (externalCmdService *ExternalCmdService) runParkedProbe(breaker *circuitBreaker) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "runParkedProbe",
+	})
+
+	logger = logger.WithField(logFieldJob, breaker.jobName)
+
+	externalCmdService.circuitBreakersLock.Lock()
+	if externalCmdService.circuitBreakers[breaker.jobName] != breaker || breaker.state != CircuitBreakerStateHalfOpen || len(breaker.probeRunID) > 0 {
+		externalCmdService.circuitBreakersLock.Unlock()
+		return
+	}
+
+	if len(breaker.parked) == 0 {
+		externalCmdService.circuitBreakersLock.Unlock()
+		logger.Info("no run parked, the next run is a probe")
+		return
+	}
+
+	probe := breaker.parked[0]
+	breaker.parked = breaker.parked[1:]
+	breaker.probeRunID = probe.run.id
+	externalCmdService.circuitBreakersLock.Unlock()
+
+	metricRunsParked.WithLabelValues(breaker.jobName).Dec()
+	logger.WithFields(getRunLogFields(probe.run)).Info("run a probe")
+
+	err := externalCmdService.launchRun(probe.ctx, probe.run)
+	if err != nil {
+		// terminating
+		externalCmdService.abandonParkedRun(probe.run)
+	}
+}
+
+// releaseParkedRuns starts parked runs gradually in background, not to overload the recovered dependency
+// runs not started yet are parked again if the breaker opens again, nil breaker if it is removed
+func (externalCmdService *ExternalCmdService) releaseParkedRuns(breaker *circuitBreaker, parked []*pendingRun) {
+	if len(parked) == 0 {
+		return
+	}
+
+	go externalCmdService.startParkedRuns(breaker, parked)
+}
+
+func (externalCmdService *ExternalCmdService) startParkedRuns(breaker *circuitBreaker, parked []*pendingRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "startParkedRuns",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	jobName := parked[0].run.job.Name
+	logger = logger.WithField(logFieldJob, jobName)
+
+	interval := time.Second / time.Duration(commons.CircuitBreakerReleaseRate)
+
+	for idx, pending := range parked {
+		if idx > 0 {
+			select {
+			case <-externalCmdService.terminateChan:
+				// spooled events are kept to replay
+				metricRunsParked.WithLabelValues(jobName).Sub(float64(len(parked) - idx))
+				logger.Warnf("service is terminating, discard %d parked runs", len(parked)-idx)
+				for _, abandoned := range parked[idx:] {
+					externalCmdService.abandonParkedRun(abandoned.run)
+				}
+				return
+			case <-time.After(interval):
+			}
+		}
+
+		if breaker != nil {
+			externalCmdService.circuitBreakersLock.Lock()
+			if externalCmdService.circuitBreakers[jobName] == breaker && breaker.state != CircuitBreakerStateClosed {
+				// park again in order
+				breaker.parked = append(append([]*pendingRun{}, parked[idx:]...), breaker.parked...)
+				externalCmdService.circuitBreakersLock.Unlock()
+
+				logger.Infof("circuit breaker is %s again, park %d runs not started", breaker.state, len(parked)-idx)
+				return
+			}
+			externalCmdService.circuitBreakersLock.Unlock()
+		}
+
+		metricRunsParked.WithLabelValues(jobName).Dec()
+
+		err := externalCmdService.launchRun(pending.ctx, pending.run)
+		if err != nil {
+			externalCmdService.abandonParkedRun(pending.run)
+		}
+	}
+}
+
+// abandonParkedRun gives up a parked run not started, spooled events are kept to replay
+func (externalCmdService *ExternalCmdService) abandonParkedRun(run *jobRun) {
+	if len(run.partition) > 0 {
+		externalCmdService.releasePartition(run.partition)
+	}
+}
+
+// removeCircuitBreaker forgets the breaker, returns runs parked, must be called with circuitBreakersLock
+func (externalCmdService *ExternalCmdService) removeCircuitBreaker(breaker *circuitBreaker) []*pendingRun {
+	if breaker.timer != nil {
+		breaker.timer.Stop()
+	}
+
+	for _, state := range []string{CircuitBreakerStateClosed, CircuitBreakerStateOpen, CircuitBreakerStateHalfOpen} {
+		metricCircuitBreakerState.DeleteLabelValues(breaker.jobName, state)
+	}
+
+	delete(externalCmdService.circuitBreakers, breaker.jobName)
+	return breaker.parked
+}
+
+// dropParkedRuns discards runs parked by open circuit breakers, used on shutdown
+func (externalCmdService *ExternalCmdService) dropParkedRuns() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "dropParkedRuns",
+	})
+
+	externalCmdService.circuitBreakersLock.Lock()
+	defer externalCmdService.circuitBreakersLock.Unlock()
+
+	for _, breaker := range externalCmdService.circuitBreakers {
+		if breaker.timer != nil {
+			breaker.timer.Stop()
+		}
+
+		if len(breaker.parked) > 0 {
+			// spooled events are kept to replay
+			logger.WithField(logFieldJob, breaker.jobName).Warnf("circuit breaker is %s on shutdown, discard %d parked runs", breaker.state, len(breaker.parked))
+			metricRunsParked.WithLabelValues(breaker.jobName).Sub(float64(len(breaker.parked)))
+			breaker.parked = []*pendingRun{}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+)
+
+func TestCircuitBreakerProbeNotRunIsReplaced(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.DataRootPath = t.TempDir()
+	config.JobFilePath = filepath.Join(config.DataRootPath, "jobs.yaml")
+
+	externalCmdService, err := CreateExternalCmdService(&S3DataWatcherService{
+		config: config,
+	})
+	if err != nil {
+		t.Fatalf("failed to create external cmd service: %v", err)
+	}
+	t.Cleanup(externalCmdService.Release)
+
+	job := &Job{
+		Name:    "test",
+		Command: "/bin/true",
+		CircuitBreaker: &JobCircuitBreaker{
+			Threshold: 1,
+		},
+	}
+
+	probeRun, err := newRecordJobRun(job, newTestRecord("bucket", "key1", "0001"))
+	if err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+
+	breaker := &circuitBreaker{
+		jobName:    job.Name,
+		probeRunID: probeRun.id,
+		parked:     []*pendingRun{},
+	}
+	breaker.setState(CircuitBreakerStateHalfOpen)
+	externalCmdService.circuitBreakers[job.Name] = breaker
+
+	// the probe is abandoned before its first attempt
+	externalCmdService.recordRunResult(probeRun)
+
+	statuses := externalCmdService.GetCircuitBreakers()
+	if len(statuses) != 1 || statuses[0].State != CircuitBreakerStateHalfOpen || len(statuses[0].ProbeRunID) > 0 {
+		t.Fatalf("probe must be cleared, got %+v", statuses)
+	}
+
+	// the next run becomes the probe
+	nextRun, err := newRecordJobRun(job, newTestRecord("bucket", "key2", "0002"))
+	if err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+
+	admitted, err := externalCmdService.admitRun(context.Background(), nextRun)
+	if err != nil || !admitted {
+		t.Fatalf("next run must be admitted as a probe: %v", err)
+	}
+
+	statuses = externalCmdService.GetCircuitBreakers()
+	if statuses[0].ProbeRunID != nextRun.id {
+		t.Fatalf("next run must be the probe, got %q", statuses[0].ProbeRunID)
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/xerrors"
+)
+
+const (
+	completionPublishTimeout time.Duration = 10 * time.Second
+)
+
+// JobCompletionEvent is published to the completion subject when a job run finishes
+type JobCompletionEvent struct {
+	RunID      string    `json:"run_id"`
+	Job        string    `json:"job"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	StatusCode int       `json:"status_code,omitempty"` // of http action
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	Duration   float64   `json:"duration"` // seconds
+	Attempts   int       `json:"attempts"`
+	// records given to the run, up to CompletionEventMaxRecords
+	Records      []events.S3EventRecord `json:"records"`
+	RecordsTotal int                    `json:"records_total"`
+	Result       *JobResult             `json:"result,omitempty"` // reported by the job
+	Output       string                 `json:"output,omitempty"` // the last bytes of stdout and stderr
+	Error        string                 `json:"error,omitempty"`
+}
+
+// newJobCompletionEvent returns a completion event of the finished run
+func newJobCompletionEvent(run *jobRun) *JobCompletionEvent {
+	records := run.records
+	if len(records) > commons.CompletionEventMaxRecords {
+		records = records[:commons.CompletionEventMaxRecords]
+	}
+
+	event := &JobCompletionEvent{
+		RunID:        run.id,
+		Job:          run.job.Name,
+		Status:       run.getStatus(),
+		ExitCode:     run.exitCode,
+		StatusCode:   run.statusCode,
+		StartTime:    run.startTime,
+		EndTime:      run.endTime,
+		Duration:     run.endTime.Sub(run.startTime).Seconds(),
+		Attempts:     run.attempts,
+		Records:      records,
+		RecordsTotal: len(run.records),
+		Result:       run.result,
+		Output:       run.output.String(),
+	}
+
+	if run.err != nil {
+		event.Error = run.err.Error()
+	}
+
+	return event
+}
+
+// getCompletionSubject returns the subject template for completion events of the job, empty if disabled
+func (externalCmdService *ExternalCmdService) getCompletionSubject(job *Job) string {
+	if len(job.CompletionSubject) > 0 {
+		return job.CompletionSubject
+	}
+	return externalCmdService.service.config.NatsConfig.CompletionSubject
+}
+
+// completionMessage is a completion event waiting to be published
+type completionMessage struct {
+	job   string
+	runID string
+	msg   *nats.Msg
+}
+
+// publishCompletionEvent queues the completion event of the finished run to publish in background
+func (externalCmdService *ExternalCmdService) publishCompletionEvent(run *jobRun) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "publishCompletionEvent",
+	})
+
+	subjectTemplate := externalCmdService.getCompletionSubject(run.job)
+	if len(subjectTemplate) == 0 {
+		return nil
+	}
+
+	subject, err := renderActionTemplate("completion subject", subjectTemplate, run)
+	if err != nil {
+		return err
+	}
+
+	err = validatePublishSubject(subject)
+	if err != nil {
+		return err
+	}
+
+	if matchNatsSubject(externalCmdService.service.config.NatsConfig.Subject, subject) {
+		return xerrors.Errorf("cannot publish completion event to %s, the subject is watched for events", subject)
+	}
+
+	eventJson, err := json.Marshal(newJobCompletionEvent(run))
+	if err != nil {
+		return xerrors.Errorf("failed to marshal completion event: %w", err)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = eventJson
+
+	// subscribers can continue the trace of the run
+	otel.GetTextMapPropagator().Inject(run.ctx, natsHeaderCarrier(msg.Header))
+
+	externalCmdService.completionQueueLock.Lock()
+	defer externalCmdService.completionQueueLock.Unlock()
+
+	if externalCmdService.completionQueueClosed {
+		return xerrors.Errorf("cannot publish completion event to %s, shutting down", subject)
+	}
+
+	select {
+	case externalCmdService.completionQueue <- &completionMessage{
+		job:   run.job.Name,
+		runID: run.id,
+		msg:   msg,
+	}:
+	default:
+		return xerrors.Errorf("cannot publish completion event to %s, too many events waiting (%d)", subject, commons.CompletionQueueSize)
+	}
+
+	logger.WithFields(getRunLogFields(run)).Debugf("queued completion event to %s", subject)
+	return nil
+}
+
+// completionPublisher publishes completion events queued until the queue is closed
+func (externalCmdService *ExternalCmdService) completionPublisher() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "completionPublisher",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer externalCmdService.completionWaitGroup.Done()
+
+	for completion := range externalCmdService.completionQueue {
+		completionLogger := logger.WithFields(log.Fields{
+			logFieldJob:   completion.job,
+			logFieldRunID: completion.runID,
+		})
+
+		select {
+		case <-externalCmdService.completionStopChan:
+			completionLogger.Warnf("drop completion event to %s on shutdown", completion.msg.Subject)
+			metricCompletionPublishFailures.WithLabelValues(completion.job).Inc()
+			continue
+		default:
+		}
+
+		err := externalCmdService.publish(completion.msg, completionPublishTimeout)
+		if err != nil {
+			completionLogger.WithError(err).Error("failed to publish completion event")
+			metricCompletionPublishFailures.WithLabelValues(completion.job).Inc()
+			continue
+		}
+
+		completionLogger.Debugf("published completion event to %s", completion.msg.Subject)
+	}
+}
+
+// stopCompletionPublisher publishes completion events left, and drops them if it takes too long
+func (externalCmdService *ExternalCmdService) stopCompletionPublisher() {
+	externalCmdService.completionQueueLock.Lock()
+	if !externalCmdService.completionQueueClosed {
+		externalCmdService.completionQueueClosed = true
+		close(externalCmdService.completionQueue)
+	}
+	externalCmdService.completionQueueLock.Unlock()
+
+	doneChan := make(chan struct{})
+	go func() {
+		externalCmdService.completionWaitGroup.Wait()
+		close(doneChan)
+	}()
+
+	select {
+	case <-doneChan:
+		return
+	case <-time.After(completionPublishTimeout):
+	}
+
+	close(externalCmdService.completionStopChan)
+	<-doneChan
+}
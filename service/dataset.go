@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	datasetCompleteByQuietPeriod string = "quiet_period"
+	datasetCompleteByMarker      string = "marker"
+	datasetCompleteByOverflow    string = "overflow"
+	datasetCompleteByMaxAge      string = "max_age"
+)
+
+// jobDataset is records of a dataset waiting for the dataset to be complete
+type jobDataset struct {
+	ctx      context.Context // of the first record
+	job      *Job
+	bucket   string
+	id       string
+	records  []events.S3EventRecord
+	keys     map[string]int // index of records, to keep the latest record of a key
+	marker   *events.S3EventRecord
+	created  time.Time
+	timer    *time.Timer // quiet period
+	ageTimer *time.Timer // max age
+
+	spoolEntries []*spoolEntry
+}
+
+// DatasetPayload is sent to a dataset job via STDIN
+type DatasetPayload struct {
+	Job        string   `json:"job"`
+	Bucket     string   `json:"bucket"`
+	Dataset    string   `json:"dataset"`
+	CompleteBy string   `json:"complete_by"`
+	Marker     string   `json:"marker,omitempty"`
+	Keys       []string `json:"keys"`
+}
+
+// makeDatasetKey makes a key of the job and the dataset
+func makeDatasetKey(job *Job, bucket string, datasetID string) string {
+	return job.Name + "\x00" + bucket + "\x00" + datasetID
+}
+
+// stopTimers stops timers of the dataset
+func (dataset *jobDataset) stopTimers() {
+	if dataset.timer != nil {
+		dataset.timer.Stop()
+	}
+
+	if dataset.ageTimer != nil {
+		dataset.ageTimer.Stop()
+	}
+}
+
+// getDatasetID returns the dataset id of the object key, the first capture group of the pattern
+func getDatasetID(dataset *JobDataset, key string) (string, bool) {
+	pattern := dataset.patternRegexp
+	if pattern == nil {
+		// not validated
+		compiledPattern, err := regexp.Compile(dataset.Pattern)
+		if err != nil {
+			return "", false
+		}
+		pattern = compiledPattern
+	}
+
+	matches := pattern.FindStringSubmatch(key)
+	if len(matches) < 2 || len(matches[1]) == 0 {
+		return "", false
+	}
+
+	return matches[1], true
+}
+
+// datasetJob adds the record to its dataset, the dataset runs the job once when it is complete
+func (externalCmdService *ExternalCmdService) datasetJob(ctx context.Context, job *Job, record events.S3EventRecord) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "datasetJob",
+	})
+
+	logger = logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, job.Name)
+
+	bucket := record.S3.Bucket.Name
+	objectKey := record.S3.Object.Key
+
+	datasetID, ok := getDatasetID(job.Dataset, objectKey)
+	if !ok {
+		logger.Debugf("object key does not match to dataset pattern %q, ignore", job.Dataset.Pattern)
+		getSpoolEntry(ctx).release()
+		return
+	}
+
+	key := makeDatasetKey(job, bucket, datasetID)
+	isMarker := len(job.Dataset.Marker) > 0 && path.Base(objectKey) == job.Dataset.Marker
+
+	externalCmdService.datasetsLock.Lock()
+
+	var evictedDataset *jobDataset
+
+	dataset, ok := externalCmdService.datasets[key]
+	if !ok {
+		if len(externalCmdService.datasets) >= commons.DatasetMaxPending {
+			// run the oldest rather than grow without bound
+			evictedDataset = externalCmdService.removeOldestDataset()
+		}
+
+		dataset = &jobDataset{
+			ctx:     ctx,
+			bucket:  bucket,
+			id:      datasetID,
+			records: []events.S3EventRecord{},
+			keys:    map[string]int{},
+			created: time.Now(),
+		}
+
+		// the marker may never arrive
+		ageDataset := dataset
+		dataset.ageTimer = time.AfterFunc(job.Dataset.GetMaxAge(), func() {
+			externalCmdService.fireDataset(key, ageDataset, datasetCompleteByMaxAge)
+		})
+
+		externalCmdService.datasets[key] = dataset
+	}
+
+	// use the latest job definition
+	dataset.job = job
+
+	if spoolEntry := getSpoolEntry(ctx); spoolEntry != nil {
+		dataset.spoolEntries = append(dataset.spoolEntries, spoolEntry)
+	}
+
+	if isMarker {
+		dataset.marker = &record
+	} else if idx, ok := dataset.keys[objectKey]; ok {
+		dataset.records[idx] = record
+	} else {
+		dataset.keys[objectKey] = len(dataset.records)
+		dataset.records = append(dataset.records, record)
+	}
+
+	completeBy := ""
+	if isMarker {
+		completeBy = datasetCompleteByMarker
+	}
+
+	if len(completeBy) > 0 {
+		dataset.stopTimers()
+		delete(externalCmdService.datasets, key)
+	} else if job.Dataset.QuietPeriod > 0 {
+		// restart the quiet period
+		if dataset.timer != nil {
+			dataset.timer.Stop()
+		}
+
+		timerDataset := dataset
+		dataset.timer = time.AfterFunc(job.Dataset.GetQuietPeriod(), func() {
+			externalCmdService.fireDataset(key, timerDataset, datasetCompleteByQuietPeriod)
+		})
+	}
+
+	members := len(dataset.records)
+	externalCmdService.datasetsLock.Unlock()
+
+	logger.Debugf("added a record to dataset %q (%d objects)", datasetID, members)
+
+	if evictedDataset != nil {
+		logger.Warnf("too many datasets pending (%d), run the oldest dataset %q before it is complete", commons.DatasetMaxPending, evictedDataset.id)
+		externalCmdService.runDataset(evictedDataset, datasetCompleteByOverflow)
+	}
+
+	if len(completeBy) > 0 {
+		externalCmdService.runDataset(dataset, completeBy)
+	}
+}
+
+// removeOldestDataset removes the dataset having the oldest first record, must be called with datasetsLock
+func (externalCmdService *ExternalCmdService) removeOldestDataset() *jobDataset {
+	oldestKey := ""
+	var oldestDataset *jobDataset
+	for key, dataset := range externalCmdService.datasets {
+		if oldestDataset == nil || dataset.created.Before(oldestDataset.created) {
+			oldestKey = key
+			oldestDataset = dataset
+		}
+	}
+
+	if oldestDataset == nil {
+		return nil
+	}
+
+	oldestDataset.stopTimers()
+	delete(externalCmdService.datasets, oldestKey)
+	return oldestDataset
+}
+
+// fireDataset runs the job when no records arrive for the quiet period, or when the dataset gets too old
+func (externalCmdService *ExternalCmdService) fireDataset(key string, dataset *jobDataset, completeBy string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "fireDataset",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	externalCmdService.datasetsLock.Lock()
+	if externalCmdService.datasets[key] != dataset {
+		// already complete
+		externalCmdService.datasetsLock.Unlock()
+		return
+	}
+
+	dataset.stopTimers()
+	delete(externalCmdService.datasets, key)
+	externalCmdService.datasetsLock.Unlock()
+
+	if completeBy == datasetCompleteByMaxAge {
+		logger.WithField(logFieldJob, dataset.job.Name).Warnf("dataset %q is not complete in %s, run with %d objects", dataset.id, dataset.job.Dataset.GetMaxAge(), len(dataset.records))
+	}
+
+	externalCmdService.runDataset(dataset, completeBy)
+}
+
+// runDataset runs the job with the list of objects in the dataset
+func (externalCmdService *ExternalCmdService) runDataset(dataset *jobDataset, completeBy string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "runDataset",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	logger = logger.WithField(logFieldJob, dataset.job.Name)
+
+	payload := DatasetPayload{
+		Job:        dataset.job.Name,
+		Bucket:     dataset.bucket,
+		Dataset:    dataset.id,
+		CompleteBy: completeBy,
+		Keys:       make([]string, 0, len(dataset.records)),
+	}
+
+	for _, record := range dataset.records {
+		payload.Keys = append(payload.Keys, record.S3.Object.Key)
+	}
+
+	// the run needs at least one record, the marker is also a record of the dataset
+	records := dataset.records
+	if dataset.marker != nil {
+		payload.Marker = dataset.marker.S3.Object.Key
+		records = append(records, *dataset.marker)
+	}
+
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		logger.WithError(err).Errorf("failed to marshal dataset %q", dataset.id)
+		releaseSpoolEntries(dataset.spoolEntries)
+		return
+	}
+
+	logger.Infof("dataset %q is complete by %s (%d objects)", dataset.id, completeBy, len(payload.Keys))
+
+	run := newJobRun(dataset.job, records, payloadJson)
+	run.spoolEntries = dataset.spoolEntries
+
+	externalCmdService.startRun(dataset.ctx, run)
+}
+
+// dropDatasets discards datasets not complete, used on shutdown
+func (externalCmdService *ExternalCmdService) dropDatasets() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "dropDatasets",
+	})
+
+	externalCmdService.datasetsLock.Lock()
+	datasets := externalCmdService.datasets
+	externalCmdService.datasets = map[string]*jobDataset{}
+	externalCmdService.datasetsLock.Unlock()
+
+	for _, dataset := range datasets {
+		dataset.stopTimers()
+
+		// spooled events are kept to replay
+		logger.WithField(logFieldJob, dataset.job.Name).Warnf("dataset %q is not complete on shutdown, discard %d objects", dataset.id, len(dataset.records))
+	}
+}
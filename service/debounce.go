@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+// debounceEntry is a job run waiting for the debounce window to end
+type debounceEntry struct {
+	ctx    context.Context
+	job    *Job
+	record events.S3EventRecord
+	timer  *time.Timer
+	events int
+}
+
+// makeDebounceKey makes a key of the job and the object
+func makeDebounceKey(job *Job, record events.S3EventRecord) string {
+	return job.Name + "\x00" + record.S3.Bucket.Name + "\x00" + record.S3.Object.Key
+}
+
+// debounceJob delays running the job until the debounce window of the job ends
+// events for the same object arriving within the window collapse into one run with the latest record
+func (externalCmdService *ExternalCmdService) debounceJob(ctx context.Context, job *Job, record events.S3EventRecord) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "debounceJob",
+	})
+
+	logger = logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, job.Name)
+
+	key := makeDebounceKey(job, record)
+
+	externalCmdService.debounceLock.Lock()
+
+	if entry, ok := externalCmdService.debounceEntries[key]; ok {
+		// coalesce, run with the latest record
+		supersededSpoolEntry := getSpoolEntry(entry.ctx)
+		entry.ctx = ctx
+		entry.job = job
+		entry.record = record
+		entry.events++
+		coalescedEvents := entry.events
+		externalCmdService.debounceLock.Unlock()
+
+		// the latest event replaces the superseded one
+		supersededSpoolEntry.release()
+
+		logger.Debugf("coalesced %d events", coalescedEvents)
+		metricEventsCoalesced.WithLabelValues(job.Name).Inc()
+		return
+	}
+
+	if externalCmdService.debounceClosed || len(externalCmdService.debounceEntries) >= commons.DebounceMaxPending {
+		// run immediately rather than grow without bound
+		closed := externalCmdService.debounceClosed
+		externalCmdService.debounceLock.Unlock()
+
+		if !closed {
+			logger.Warnf("too many debounced jobs pending (%d), run without debounce", commons.DebounceMaxPending)
+		}
+
+		externalCmdService.dispatchJob(ctx, job, record)
+		return
+	}
+
+	entry := &debounceEntry{
+		ctx:    ctx,
+		job:    job,
+		record: record,
+		events: 1,
+	}
+	entry.timer = time.AfterFunc(job.GetDebounce(), func() {
+		externalCmdService.fireDebounce(key, entry)
+	})
+
+	externalCmdService.debounceEntries[key] = entry
+	externalCmdService.debounceLock.Unlock()
+
+	logger.Debugf("debounce for %f seconds", job.GetDebounce().Seconds())
+}
+
+// fireDebounce runs the job when the debounce window ends
+func (externalCmdService *ExternalCmdService) fireDebounce(key string, entry *debounceEntry) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "fireDebounce",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	externalCmdService.debounceLock.Lock()
+	if externalCmdService.debounceEntries[key] != entry {
+		// flushed
+		externalCmdService.debounceLock.Unlock()
+		return
+	}
+
+	delete(externalCmdService.debounceEntries, key)
+	externalCmdService.debounceLock.Unlock()
+
+	externalCmdService.dispatchJob(entry.ctx, entry.job, entry.record)
+}
+
+// flushDebounce runs all pending jobs immediately, used on shutdown
+func (externalCmdService *ExternalCmdService) flushDebounce() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "flushDebounce",
+	})
+
+	externalCmdService.debounceLock.Lock()
+	externalCmdService.debounceClosed = true
+	entries := externalCmdService.debounceEntries
+	externalCmdService.debounceEntries = map[string]*debounceEntry{}
+	externalCmdService.debounceLock.Unlock()
+
+	if len(entries) > 0 {
+		logger.Infof("running %d debounced jobs", len(entries))
+	}
+
+	for _, entry := range entries {
+		entry.timer.Stop()
+		externalCmdService.dispatchJob(entry.ctx, entry.job, entry.record)
+	}
+}
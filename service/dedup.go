@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ForceHeaderName is a Nats message header to run jobs for duplicate events
+	ForceHeaderName string = "X-S3-Data-Watcher-Force"
+
+	eventDropReasonDuplicate string = "duplicate"
+
+	dedupBucketName    string        = "events"
+	dedupOpenTimeout   time.Duration = 1 * time.Second
+	dedupPruneInterval time.Duration = 1 * time.Hour
+	dedupTimeLength    int           = 8
+)
+
+type forceRunContextKey struct{}
+
+// withForceRun returns a context forcing jobs to run for duplicate events
+func withForceRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceRunContextKey{}, true)
+}
+
+// isForceRun returns true if the context forces jobs to run
+func isForceRun(ctx context.Context) bool {
+	force, _ := ctx.Value(forceRunContextKey{}).(bool)
+	return force
+}
+
+// isForceHeaderSet returns true if the force header is set to a true value
+func isForceHeaderSet(header nats.Header) bool {
+	value := strings.ToLower(natsHeaderCarrier(header).Get(ForceHeaderName))
+	switch value {
+	case "1", "true", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// makeDedupKey makes a key identifying the event for the job
+// returns false if the record has neither sequencer nor eTag
+func makeDedupKey(job *Job, record events.S3EventRecord) (string, bool) {
+	object := record.S3.Object
+
+	version := object.Sequencer
+	if len(version) == 0 {
+		version = object.ETag
+	}
+
+	if len(version) == 0 {
+		return "", false
+	}
+
+	return strings.Join([]string{job.Name, record.S3.Bucket.Name, object.Key, object.VersionID, version}, "\x00"), true
+}
+
+// DedupService remembers events processed to suppress duplicates
+type DedupService struct {
+	service       *S3DataWatcherService
+	db            *bolt.DB
+	ttl           time.Duration
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// CreateDedupService creates a Dedup service object and opens the store
+func CreateDedupService(service *S3DataWatcherService) (*DedupService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateDedupService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	dedupFilePath := service.config.GetDedupFilePath()
+
+	db, err := bolt.Open(dedupFilePath, 0644, &bolt.Options{
+		Timeout: dedupOpenTimeout,
+	})
+	if err != nil {
+		err = xerrors.Errorf("failed to open dedup store %s: %w", dedupFilePath, err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(dedupBucketName))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		err = xerrors.Errorf("failed to create dedup bucket: %w", err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	dedupService := &DedupService{
+		service:       service,
+		db:            db,
+		ttl:           service.config.GetDedupTTL(),
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}
+
+	logger.Infof("suppressing duplicate events for %f seconds using %s", dedupService.ttl.Seconds(), dedupFilePath)
+
+	dedupService.waitGroup.Add(1)
+	go dedupService.pruneLoop()
+
+	return dedupService, nil
+}
+
+// Release releases all resources, closing the store
+func (dedupService *DedupService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "DedupService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	close(dedupService.terminateChan)
+	dedupService.waitGroup.Wait()
+
+	err := dedupService.db.Close()
+	if err != nil {
+		logger.WithError(err).Warn("failed to close dedup store")
+	}
+}
+
+// Check returns the time the event was processed if it is a duplicate, otherwise returns zero time
+func (dedupService *DedupService) Check(key string) (time.Time, error) {
+	now := time.Now()
+	processed := time.Time{}
+
+	err := dedupService.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(dedupBucketName)).Get([]byte(key))
+		if len(value) == dedupTimeLength {
+			seen := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+			if now.Sub(seen) < dedupService.ttl {
+				processed = seen
+			}
+		}
+		return nil
+	})
+
+	return processed, err
+}
+
+// Mark remembers the event, called after a run for the event succeeds
+func (dedupService *DedupService) Mark(key string) error {
+	return dedupService.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(dedupBucketName)).Put([]byte(key), makeDedupValue(time.Now()))
+	})
+}
+
+func (dedupService *DedupService) pruneLoop() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "DedupService",
+		"function": "pruneLoop",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer dedupService.waitGroup.Done()
+
+	for {
+		pruned, err := dedupService.prune(time.Now().Add(-dedupService.ttl))
+		if err != nil {
+			logger.WithError(err).Warn("failed to prune dedup store")
+		} else if pruned > 0 {
+			logger.Debugf("pruned %d expired events", pruned)
+		}
+
+		select {
+		case <-dedupService.terminateChan:
+			return
+		case <-time.After(dedupPruneInterval):
+		}
+	}
+}
+
+// prune deletes events seen before the given time
+func (dedupService *DedupService) prune(before time.Time) (int, error) {
+	pruned := 0
+	err := dedupService.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(dedupBucketName)).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if len(value) == dedupTimeLength && !time.Unix(0, int64(binary.BigEndian.Uint64(value))).Before(before) {
+				continue
+			}
+
+			err := cursor.Delete()
+			if err != nil {
+				return err
+			}
+			pruned++
+		}
+		return nil
+	})
+
+	return pruned, err
+}
+
+func makeDedupValue(seen time.Time) []byte {
+	value := make([]byte, dedupTimeLength)
+	binary.BigEndian.PutUint64(value, uint64(seen.UnixNano()))
+	return value
+}
+
+// isDuplicate returns true if the job already ran successfully for the event
+func (externalCmdService *ExternalCmdService) isDuplicate(ctx context.Context, job *Job, record events.S3EventRecord) bool {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "isDuplicate",
+	})
+
+	dedupService := externalCmdService.service.dedupService
+	if dedupService == nil {
+		return false
+	}
+
+	logger = logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, job.Name)
+
+	key, ok := makeDedupKey(job, record)
+	if !ok {
+		logger.Debug("no sequencer or etag to identify the event, skip duplicate check")
+		return false
+	}
+
+	if isForceRun(ctx) {
+		logger.Info("forced to run, skip duplicate check")
+		metricEventsForced.WithLabelValues(job.Name).Inc()
+		return false
+	}
+
+	processed, err := dedupService.Check(key)
+	if err != nil {
+		// run rather than lose the event
+		logger.WithError(err).Warn("failed to check duplicate event")
+		return false
+	}
+
+	if processed.IsZero() {
+		return false
+	}
+
+	logger.Infof("drop duplicate event already processed at %s", processed.Format(time.RFC3339))
+	metricEventsDropped.WithLabelValues(job.Name, eventDropReasonDuplicate).Inc()
+	return true
+}
+
+// markRunProcessed remembers events of the run if the run succeeded
+// events of failed or abandoned runs are not remembered, so redelivered or replayed events run again
+func (externalCmdService *ExternalCmdService) markRunProcessed(run *jobRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "markRunProcessed",
+	})
+
+	dedupService := externalCmdService.service.dedupService
+	if dedupService == nil || run.previous != nil {
+		// chained runs are not started by events
+		return
+	}
+
+	status := run.getStatus()
+	if status != JobRunStatusSucceeded && status != JobRunStatusSkipped {
+		return
+	}
+
+	for _, record := range run.records {
+		key, ok := makeDedupKey(run.job, record)
+		if !ok {
+			continue
+		}
+
+		err := dedupService.Mark(key)
+		if err != nil {
+			logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, run.job.Name).WithError(err).Warn("failed to remember the event")
+		}
+	}
+}
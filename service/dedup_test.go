@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	"golang.org/x/xerrors"
+)
+
+// newDedupTestService returns a service with dedup and external cmd services in a temp dir
+func newDedupTestService(t *testing.T) *S3DataWatcherService {
+	t.Helper()
+
+	config := commons.NewDefaultConfig()
+	config.DataRootPath = t.TempDir()
+	config.JobFilePath = filepath.Join(config.DataRootPath, "jobs.yaml")
+	config.DedupTTL = 3600
+
+	service := &S3DataWatcherService{
+		config: config,
+	}
+
+	dedupService, err := CreateDedupService(service)
+	if err != nil {
+		t.Fatalf("failed to create dedup service: %v", err)
+	}
+	t.Cleanup(dedupService.Release)
+	service.dedupService = dedupService
+
+	externalCmdService, err := CreateExternalCmdService(service)
+	if err != nil {
+		t.Fatalf("failed to create external cmd service: %v", err)
+	}
+	t.Cleanup(externalCmdService.Release)
+	service.externalCmdService = externalCmdService
+
+	return service
+}
+
+// finishTestRun finishes a run of the record as if it ran once with the given error
+func finishTestRun(t *testing.T, externalCmdService *ExternalCmdService, job *Job, record events.S3EventRecord, runErr error) {
+	t.Helper()
+
+	run, err := newRecordJobRun(job, record)
+	if err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+
+	run.ctx, run.span = tracer.Start(context.Background(), "test")
+	run.attempts = 1
+	run.err = runErr
+
+	externalCmdService.runningJobs[run.id] = run
+	externalCmdService.finishRun(run)
+}
+
+func TestDedupFailedRunIsRedelivered(t *testing.T) {
+	service := newDedupTestService(t)
+	externalCmdService := service.externalCmdService
+
+	job := &Job{
+		Name:    "test",
+		Command: "/bin/true",
+	}
+	record := newTestRecord("bucket", "key", "0001")
+	ctx := context.Background()
+
+	if externalCmdService.isDuplicate(ctx, job, record) {
+		t.Fatal("first delivery is dropped as duplicate")
+	}
+
+	finishTestRun(t, externalCmdService, job, record, xerrors.Errorf("failed"))
+
+	if externalCmdService.isDuplicate(ctx, job, record) {
+		t.Fatal("redelivery after a failed run is dropped as duplicate")
+	}
+
+	finishTestRun(t, externalCmdService, job, record, nil)
+
+	if !externalCmdService.isDuplicate(ctx, job, record) {
+		t.Fatal("redelivery after a succeeded run is not dropped")
+	}
+
+	if !isForceRun(withForceRun(ctx)) || externalCmdService.isDuplicate(withForceRun(ctx), job, record) {
+		t.Fatal("forced redelivery is dropped as duplicate")
+	}
+
+	// other jobs are not affected
+	otherJob := &Job{
+		Name:    "other",
+		Command: "/bin/true",
+	}
+	if externalCmdService.isDuplicate(ctx, otherJob, record) {
+		t.Fatal("event of another job is dropped as duplicate")
+	}
+}
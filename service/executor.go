@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// jobExecutor performs the action of a job, retries are handled by the caller
+type jobExecutor interface {
+	// execute runs an attempt of the run, returns an error if the attempt failed
+	execute(run *jobRun) error
+	// release releases resources of the executor after the run finishes
+	release()
+}
+
+// newJobExecutor returns an executor for the action of the job
+func (externalCmdService *ExternalCmdService) newJobExecutor(job *Job) (jobExecutor, error) {
+	switch job.GetAction() {
+	case JobActionCommand:
+		return &commandExecutor{
+			externalCmdService: externalCmdService,
+		}, nil
+	case JobActionHTTP:
+		return newHTTPExecutor(externalCmdService, job)
+	case JobActionNats:
+		return &natsExecutor{
+			externalCmdService: externalCmdService,
+			action:             job.Nats,
+		}, nil
+	default:
+		return nil, xerrors.Errorf("unknown action %q", job.Action)
+	}
+}
+
+// getRunOutput returns a writer for output of the run
+func getRunOutput(run *jobRun) io.Writer {
+	if run.outputLog != nil {
+		return io.MultiWriter(run.output, run.outputLog)
+	}
+	return run.output
+}
+
+// commandExecutor runs a local executable, the payload is sent via STDIN
+type commandExecutor struct {
+	externalCmdService *ExternalCmdService
+}
+
+func (executor *commandExecutor) release() {
+}
+
+// execute runs the job process once and waits for it to exit
+func (executor *commandExecutor) execute(run *jobRun) error {
+	externalCmdService := executor.externalCmdService
+	job := run.job
+
+	cmd := exec.Command(job.Command)
+
+	// pass trace context to the job
+	cmd.Env = append(os.Environ(), getTraceContextEnvs(run.ctx)...)
+
+	// pass the result of the job chaining this run
+	previousResultFilePath, err := createPreviousResultFile(run)
+	if err != nil {
+		return err
+	}
+	if len(previousResultFilePath) > 0 {
+		defer os.Remove(previousResultFilePath)
+	}
+
+	cmd.Env = append(cmd.Env, getPreviousResultEnvs(run, previousResultFilePath)...)
+
+	// the job can report its result to RESULT_FD
+	resultFile, err := createResultFile()
+	if err != nil {
+		return err
+	}
+	defer resultFile.Close()
+
+	cmd.ExtraFiles = []*os.File{resultFile}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", resultFDEnvName, resultFD))
+
+	// run in its own process group to signal the job with its children
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+
+	// send the record via STDIN
+	cmd.Stdin = bytes.NewReader(run.payload)
+	output := getRunOutput(run)
+
+	cmd.Stdout = io.MultiWriter(output, run.stdout)
+	cmd.Stderr = output
+
+	externalCmdService.runningJobsLock.Lock()
+	err = cmd.Start()
+	if err != nil {
+		externalCmdService.runningJobsLock.Unlock()
+		return xerrors.Errorf("failed to start a job: %w", err)
+	}
+
+	run.cmd = cmd
+	atomic.StoreInt32(&run.timedOut, 0)
+	externalCmdService.runningJobsLock.Unlock()
+
+	var timer *time.Timer
+	if job.Timeout > 0 {
+		timer = time.AfterFunc(job.GetTimeout(), func() {
+			executor.killTimedOutJob(run, cmd)
+		})
+	}
+
+	err = cmd.Wait()
+
+	if timer != nil {
+		timer.Stop()
+	}
+
+	externalCmdService.runningJobsLock.Lock()
+	run.cmd = nil
+	externalCmdService.runningJobsLock.Unlock()
+
+	run.exitCode = cmd.ProcessState.ExitCode()
+
+	if run.isTimedOut() {
+		return xerrors.Errorf("job timed out after %d seconds: %w", job.Timeout, err)
+	}
+
+	return applyJobResult(run, err, resultFile)
+}
+
+// killTimedOutJob kills the job's process group when it runs longer than its timeout
+func (executor *commandExecutor) killTimedOutJob(run *jobRun, cmd *exec.Cmd) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "commandExecutor",
+		"function": "killTimedOutJob",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	atomic.StoreInt32(&run.timedOut, 1)
+
+	pid := cmd.Process.Pid
+	logger.WithFields(getRunLogFields(run)).Warnf("job timed out after %d seconds (pid %d)", run.job.Timeout, pid)
+
+	syscall.Kill(-pid, syscall.SIGTERM)
+
+	// force kill if the job ignores SIGTERM
+	time.AfterFunc(commons.JobKillGracePeriod, func() {
+		executor.externalCmdService.runningJobsLock.Lock()
+		defer executor.externalCmdService.runningJobsLock.Unlock()
+
+		if run.cmd == cmd {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+	})
+}
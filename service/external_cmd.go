@@ -1,17 +1,22 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
-	"io"
-	"os"
-	"os/exec"
 	"regexp"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"golang.org/x/xerrors"
-	"gopkg.in/yaml.v2"
 )
 
 // MinIOS3Event which wrap an array of S3EventRecord
@@ -21,24 +26,54 @@ type MinIOS3Event struct {
 	Records   []events.S3EventRecord `json:"Records"`
 }
 
-type Filter struct {
-	Events  []string `yaml:"events,omitempty"`
-	Buckets []string `yaml:"buckets,omitempty"`
-	Objects []string `yaml:"objects,omitempty"`
-}
-
-type Job struct {
-	Command string `yaml:"command"`
-	Filter  Filter `yaml:"filter,omitempty"`
-}
-
-type Jobs struct {
-	Jobs []Job `yaml:"jobs"`
-}
-
 type ExternalCmdService struct {
 	service     *S3DataWatcherService
 	jobFilePath string
+	jobDirPath  string
+
+	runningJobs     map[string]*jobRun // key: run id
+	runningJobsLock sync.Mutex
+	jobWaitGroup    sync.WaitGroup
+	terminating     bool
+	terminateChan   chan bool
+
+	debounceEntries map[string]*debounceEntry // key: job name, bucket and key
+	debounceLock    sync.Mutex
+	debounceClosed  bool
+
+	batches     map[string]*jobBatch // key: job name
+	batchesLock sync.Mutex
+	batchClosed bool
+
+	datasets     map[string]*jobDataset // key: job name, bucket and dataset id
+	datasetsLock sync.Mutex
+
+	partitions          map[string]*jobPartition    // key: job name and partition key
+	sequencers          map[string]*objectSequencer // key: job name, bucket and object key
+	sequencersPruneTime time.Time
+	partitionsLock      sync.Mutex
+
+	jobRateLimiters    map[string]*rate.Limiter // key: job name
+	bucketRateLimiters map[string]*rate.Limiter // key: bucket name
+	rateLimitersLock   sync.Mutex
+
+	circuitBreakers     map[string]*circuitBreaker // key: job name
+	circuitBreakersLock sync.Mutex
+
+	httpActionClients     map[string]*httpActionClient // key: job name
+	httpActionClientsLock sync.Mutex
+
+	publishConnection     *nats.Conn // lazy, for jobs publishing to Nats
+	publishConnectionLock sync.Mutex
+
+	completionQueue       chan *completionMessage // published in background, not to delay finishing runs
+	completionQueueClosed bool
+	completionQueueLock   sync.Mutex
+	completionStopChan    chan bool // closed to drop events left on shutdown
+	completionWaitGroup   sync.WaitGroup
+
+	processedEvents   uint64
+	dispatchStartTime int64 // unix nano, 0 if idle
 }
 
 // CreateExternalCmdService creates a ExternalCmd service object
@@ -56,19 +91,176 @@ func CreateExternalCmdService(service *S3DataWatcherService) (*ExternalCmdServic
 		return nil, err
 	}
 
+	jobDirPath, err := commons.ExpandHomeDir(service.config.JobDir)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
 	externalCmdService := &ExternalCmdService{
 		service:     service,
 		jobFilePath: jobFilePath,
+		jobDirPath:  jobDirPath,
+
+		runningJobs:     map[string]*jobRun{},
+		runningJobsLock: sync.Mutex{},
+		terminating:     false,
+		terminateChan:   make(chan bool),
+
+		debounceEntries: map[string]*debounceEntry{},
+		debounceLock:    sync.Mutex{},
+
+		batches:     map[string]*jobBatch{},
+		batchesLock: sync.Mutex{},
+
+		datasets:     map[string]*jobDataset{},
+		datasetsLock: sync.Mutex{},
+
+		partitions:     map[string]*jobPartition{},
+		sequencers:     map[string]*objectSequencer{},
+		partitionsLock: sync.Mutex{},
+
+		jobRateLimiters:    map[string]*rate.Limiter{},
+		bucketRateLimiters: newBucketRateLimiters(service.config.BucketRateLimits),
+		rateLimitersLock:   sync.Mutex{},
+
+		circuitBreakers:     map[string]*circuitBreaker{},
+		circuitBreakersLock: sync.Mutex{},
+
+		httpActionClients:     map[string]*httpActionClient{},
+		httpActionClientsLock: sync.Mutex{},
+
+		publishConnectionLock: sync.Mutex{},
+
+		completionQueue:     make(chan *completionMessage, commons.CompletionQueueSize),
+		completionQueueLock: sync.Mutex{},
+		completionStopChan:  make(chan bool),
+		completionWaitGroup: sync.WaitGroup{},
+	}
+
+	if len(service.config.NatsConfig.CompletionSubject) > 0 {
+		_, err = parseActionTemplate("completion subject", service.config.NatsConfig.CompletionSubject)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	externalCmdService.completionWaitGroup.Add(1)
+	go externalCmdService.completionPublisher()
+
+	// check job files early
+	jobs, err := externalCmdService.readJobFile()
+	if err != nil {
+		logger.WithError(err).Warn("failed to load jobs")
+	} else {
+		logger.Infof("loaded %d jobs", len(jobs.Jobs))
 	}
 
 	return externalCmdService, nil
 }
 
-// Release releases all resources
+// Release releases all resources, waiting for running jobs to finish
 func (externalCmdService *ExternalCmdService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	// run jobs waiting for debounce windows and batches, so they are drained with others
+	externalCmdService.flushDebounce()
+	externalCmdService.flushBatches()
+	externalCmdService.dropDatasets()
+	externalCmdService.dropParkedRuns()
+
+	externalCmdService.runningJobsLock.Lock()
+	externalCmdService.terminating = true
+	runningJobs := len(externalCmdService.runningJobs)
+	externalCmdService.runningJobsLock.Unlock()
+
+	// stop waiting for retries
+	close(externalCmdService.terminateChan)
+
+	drainTimeout := externalCmdService.service.config.GetDrainTimeout()
+
+	if runningJobs > 0 {
+		logger.Infof("waiting %f seconds for %d running jobs to finish", drainTimeout.Seconds(), runningJobs)
+	}
+
+	defer externalCmdService.closePublishConnection()
+	defer externalCmdService.closeHTTPActionClients()
+	// completion events of drained runs
+	defer externalCmdService.stopCompletionPublisher()
+
+	if externalCmdService.waitJobs(drainTimeout) {
+		return
+	}
+
+	// timed out
+	externalCmdService.signalRunningJobs(syscall.SIGTERM)
+
+	if externalCmdService.waitJobs(commons.JobKillGracePeriod) {
+		return
+	}
+
+	externalCmdService.signalRunningJobs(syscall.SIGKILL)
+	externalCmdService.jobWaitGroup.Wait()
+}
+
+// waitJobs waits for running jobs to finish, returns false if timed out
+func (externalCmdService *ExternalCmdService) waitJobs(timeout time.Duration) bool {
+	doneChan := make(chan struct{})
+	go func() {
+		externalCmdService.jobWaitGroup.Wait()
+		close(doneChan)
+	}()
+
+	select {
+	case <-doneChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
-func (externalCmdService *ExternalCmdService) S3EventHandler(msg []byte) {
+// signalRunningJobs sends the signal to process groups of running jobs
+func (externalCmdService *ExternalCmdService) signalRunningJobs(sig syscall.Signal) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "signalRunningJobs",
+	})
+
+	externalCmdService.runningJobsLock.Lock()
+	defer externalCmdService.runningJobsLock.Unlock()
+
+	for _, run := range externalCmdService.runningJobs {
+		if run.cancel != nil {
+			logger.Warnf("canceling http request of job (run %s)", run.id)
+			run.cancel()
+			continue
+		}
+
+		if run.cmd == nil {
+			// not started or waiting for retry
+			continue
+		}
+
+		pid := run.cmd.Process.Pid
+		logger.Warnf("sending %s to job process group %d (run %s)", sig, pid, run.id)
+
+		// negative pid to signal the process group
+		err := syscall.Kill(-pid, sig)
+		if err != nil {
+			logger.WithError(err).Warnf("failed to send %s to job process group %d", sig, pid)
+		}
+	}
+}
+
+func (externalCmdService *ExternalCmdService) S3EventHandler(ctx context.Context, subject string, msg []byte) {
 	logger := log.WithFields(log.Fields{
 		"package":  "service",
 		"struct":   "ExternalCmdService",
@@ -77,16 +269,112 @@ func (externalCmdService *ExternalCmdService) S3EventHandler(msg []byte) {
 
 	defer commons.StackTraceFromPanic(logger)
 
+	atomic.StoreInt64(&externalCmdService.dispatchStartTime, time.Now().UnixNano())
+	defer atomic.StoreInt64(&externalCmdService.dispatchStartTime, 0)
+
+	// write to the spool before processing, removed when all matching jobs finish
+	spoolService := externalCmdService.service.spoolService
+	if spoolService != nil {
+		spoolEntry, err := spoolService.Write(subject, msg, isForceRun(ctx))
+		if err != nil {
+			logger.WithError(err).Error("failed to spool event, process without spool")
+		} else {
+			ctx = withSpoolEntry(ctx, spoolEntry)
+		}
+	}
+
+	externalCmdService.handleMessage(ctx, subject, msg)
+}
+
+// ReplaySpool processes events left in the spool by the previous run
+// jobs finished for the events are not run again, and the others go through the duplicate check
+func (externalCmdService *ExternalCmdService) ReplaySpool() error {
+	spoolService := externalCmdService.service.spoolService
+	if spoolService == nil {
+		return nil
+	}
+
+	return spoolService.Replay(func(ctx context.Context, subject string, msg []byte, force bool) {
+		if force {
+			ctx = withForceRun(ctx)
+		}
+
+		externalCmdService.handleMessage(ctx, subject, msg)
+	})
+}
+
+// handleMessage decodes the message and processes the event
+func (externalCmdService *ExternalCmdService) handleMessage(ctx context.Context, subject string, msg []byte) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "handleMessage",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
 	logger.Debug(string(msg))
 
+	_, decodeSpan := tracer.Start(ctx, "decode")
 	s3Event, err := externalCmdService.convToS3Event(msg)
+	endSpanWithError(decodeSpan, err)
 	if err != nil {
 		err := xerrors.Errorf("failed to convert message to S3Event")
 		logger.Error(err)
+		metricEventsReceived.WithLabelValues(subject, "").Inc()
+		metricEventDecodeFailures.WithLabelValues(subject).Inc()
+
+		// never processable
+		getSpoolEntry(ctx).release()
 		return
 	}
 
-	externalCmdService.processEvent(s3Event)
+	for _, record := range s3Event.Records {
+		metricEventsReceived.WithLabelValues(subject, record.EventName).Inc()
+	}
+
+	externalCmdService.processEvent(ctx, s3Event)
+
+	atomic.AddUint64(&externalCmdService.processedEvents, 1)
+}
+
+// GetProcessedEvents returns the number of events processed
+func (externalCmdService *ExternalCmdService) GetProcessedEvents() uint64 {
+	return atomic.LoadUint64(&externalCmdService.processedEvents)
+}
+
+// IsReady returns ServiceNotReadyError if jobs cannot be loaded
+func (externalCmdService *ExternalCmdService) IsReady() error {
+	_, err := externalCmdService.readJobFile()
+	if err != nil {
+		return NewServiceNotReadyErrorf("failed to load jobs - %v", err)
+	}
+
+	return nil
+}
+
+// IsAlive returns error if the event dispatcher is stuck
+func (externalCmdService *ExternalCmdService) IsAlive() error {
+	dispatchStartTime := atomic.LoadInt64(&externalCmdService.dispatchStartTime)
+	if dispatchStartTime == 0 {
+		// idle
+		return nil
+	}
+
+	elapsed := time.Since(time.Unix(0, dispatchStartTime))
+	if elapsed > commons.DispatcherStallTimeout {
+		return xerrors.Errorf("event dispatcher is stuck for %f seconds", elapsed.Seconds())
+	}
+
+	return nil
+}
+
+// GetRunningJobs returns the number of running jobs
+func (externalCmdService *ExternalCmdService) GetRunningJobs() int {
+	externalCmdService.runningJobsLock.Lock()
+	defer externalCmdService.runningJobsLock.Unlock()
+
+	return len(externalCmdService.runningJobs)
 }
 
 func (externalCmdService *ExternalCmdService) convToS3Event(msg []byte) (*events.S3Event, error) {
@@ -110,21 +398,10 @@ func (externalCmdService *ExternalCmdService) convToS3Event(msg []byte) (*events
 }
 
 func (externalCmdService *ExternalCmdService) readJobFile() (*Jobs, error) {
-	jobData, err := os.ReadFile(externalCmdService.jobFilePath)
-	if err != nil {
-		return nil, err
-	}
-
-	var jobs Jobs
-	err = yaml.Unmarshal(jobData, &jobs)
-	if err != nil {
-		return nil, err
-	}
-
-	return &jobs, nil
+	return LoadJobs(externalCmdService.jobFilePath, externalCmdService.jobDirPath)
 }
 
-func (externalCmdService *ExternalCmdService) processEvent(s3event *events.S3Event) {
+func (externalCmdService *ExternalCmdService) processEvent(ctx context.Context, s3event *events.S3Event) {
 	logger := log.WithFields(log.Fields{
 		"package":  "service",
 		"struct":   "ExternalCmdService",
@@ -135,13 +412,31 @@ func (externalCmdService *ExternalCmdService) processEvent(s3event *events.S3Eve
 
 	jobs, err := externalCmdService.readJobFile()
 	if err != nil {
-		err := xerrors.Errorf("failed to read job file")
+		// keep the event in the spool to replay
+		err := xerrors.Errorf("failed to read job file: %w", err)
 		logger.Error(err)
 		return
 	}
 
-	for _, record := range s3event.Records {
-		for _, job := range jobs.Jobs {
+	// each job path holds a reference until its run finishes
+	spoolEntry := getSpoolEntry(ctx)
+	defer spoolEntry.release()
+
+	for recordIdx, record := range s3event.Records {
+		_, filterSpan := tracer.Start(ctx, "filter", trace.WithAttributes(
+			attribute.String("s3.event_name", record.EventName),
+			attribute.String("s3.bucket", record.S3.Bucket.Name),
+			attribute.String("s3.key", record.S3.Object.Key),
+		))
+
+		matchedJobs := []*Job{}
+
+		for jobIdx := range jobs.Jobs {
+			job := &jobs.Jobs[jobIdx]
+			if !job.IsEnabled() || job.Trigger == JobTriggerChain {
+				continue
+			}
+
 			accepted := true
 
 			// if no filter is given, just accept
@@ -214,13 +509,61 @@ func (externalCmdService *ExternalCmdService) processEvent(s3event *events.S3Eve
 				continue
 			}
 
-			// run job
-			externalCmdService.runJob(&job, record)
+			metricJobsMatched.WithLabelValues(job.Name).Inc()
+			matchedJobs = append(matchedJobs, job)
+		}
+
+		filterSpan.SetAttributes(attribute.Int("jobs.matched", len(matchedJobs)))
+		filterSpan.End()
+
+		logger.WithFields(getRecordLogFields(record)).Debugf("%d jobs matched", len(matchedJobs))
+
+		// run jobs
+		for _, job := range matchedJobs {
+			spoolJob := makeSpoolJobName(job, recordIdx)
+			if spoolEntry.isJobFinished(spoolJob) {
+				logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, job.Name).Debug("job already finished for the spooled event, skip")
+				continue
+			}
+
+			if externalCmdService.isDuplicate(ctx, job, record) {
+				continue
+			}
+
+			jobCtx := withSpoolEntry(ctx, spoolEntry.acquireJob(spoolJob))
+
+			if job.Trigger == JobTriggerDataset {
+				externalCmdService.datasetJob(jobCtx, job, record)
+				continue
+			}
+
+			if job.Debounce > 0 {
+				externalCmdService.debounceJob(jobCtx, job, record)
+				continue
+			}
+
+			externalCmdService.dispatchJob(jobCtx, job, record)
 		}
 	}
 }
 
-func (externalCmdService *ExternalCmdService) runJob(job *Job, record events.S3EventRecord) error {
+// dispatchJob runs the job for the record, or adds the record to the job's batch
+func (externalCmdService *ExternalCmdService) dispatchJob(ctx context.Context, job *Job, record events.S3EventRecord) {
+	if job.Batch != nil {
+		externalCmdService.batchJob(ctx, job, record)
+		return
+	}
+
+	if job.Ordering != nil {
+		externalCmdService.orderJob(ctx, job, record)
+		return
+	}
+
+	externalCmdService.runJob(ctx, job, record)
+}
+
+// runJob runs the job for the record, the record is sent via STDIN in JSON
+func (externalCmdService *ExternalCmdService) runJob(ctx context.Context, job *Job, record events.S3EventRecord) error {
 	logger := log.WithFields(log.Fields{
 		"package":  "service",
 		"struct":   "ExternalCmdService",
@@ -229,37 +572,271 @@ func (externalCmdService *ExternalCmdService) runJob(job *Job, record events.S3E
 
 	defer commons.StackTraceFromPanic(logger)
 
-	logger.Infof("running a job - %s", job.Command)
+	run, err := newRecordJobRun(job, record)
+	if err != nil {
+		logger.WithFields(getRecordLogFields(record)).Error(err)
+		getSpoolEntry(ctx).release()
+		return err
+	}
+
+	run.addSpoolEntry(getSpoolEntry(ctx))
 
-	cmd := exec.Command(job.Command)
+	return externalCmdService.startRun(ctx, run)
+}
 
-	childStdin, err := cmd.StdinPipe()
+// startRun starts the run in background, or parks it if the job's circuit breaker is open
+func (externalCmdService *ExternalCmdService) startRun(ctx context.Context, run *jobRun) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "startRun",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	admitted, err := externalCmdService.admitRun(ctx, run)
 	if err != nil {
-		logger.WithError(err).Error("failed to get job's STDIN")
+		logger.WithFields(getRunLogFields(run)).Warn(err)
 		return err
 	}
 
-	// start
-	err = cmd.Start()
-	if err != nil {
-		logger.WithError(err).Errorf("failed to start a job")
+	if !admitted {
+		// parked, started when the circuit breaker closes
+		return nil
+	}
+
+	return externalCmdService.launchRun(ctx, run)
+}
+
+// launchRun starts the run in background
+func (externalCmdService *ExternalCmdService) launchRun(ctx context.Context, run *jobRun) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "launchRun",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	job := run.job
+	record := run.record
+	logger = logger.WithFields(getRunLogFields(run))
+
+	// the span ends when the run finishes
+	run.ctx, run.span = tracer.Start(ctx, "runJob", trace.WithAttributes(
+		attribute.String("job", job.Name),
+		attribute.String("run_id", run.id),
+		attribute.String("s3.event_name", record.EventName),
+		attribute.String("s3.bucket", record.S3.Bucket.Name),
+		attribute.String("s3.key", record.S3.Object.Key),
+		attribute.Int("job.records", len(run.records)),
+	))
+
+	externalCmdService.runningJobsLock.Lock()
+	if externalCmdService.terminating {
+		externalCmdService.runningJobsLock.Unlock()
+		err := xerrors.Errorf("service is terminating, ignore job")
+		logger.Warn(err)
+		endSpanWithError(run.span, err)
 		return err
 	}
 
-	// send it to child
-	recordJson, err := json.Marshal(record)
+	// drained with running jobs, but not running until it gets under rate limits
+	externalCmdService.jobWaitGroup.Add(1)
+	externalCmdService.runningJobsLock.Unlock()
+
+	go externalCmdService.executeRun(run)
+
+	return nil
+}
+
+// registerRun adds the run to running jobs, returns error if the service is terminating
+func (externalCmdService *ExternalCmdService) registerRun(run *jobRun) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "registerRun",
+	})
+
+	externalCmdService.runningJobsLock.Lock()
+	if externalCmdService.terminating {
+		externalCmdService.runningJobsLock.Unlock()
+		return xerrors.Errorf("service is terminating, ignore job")
+	}
+
+	externalCmdService.runningJobs[run.id] = run
+	externalCmdService.runningJobsLock.Unlock()
+
+	logger.WithFields(getRunLogFields(run)).Info("running a job")
+
+	metricJobsStarted.WithLabelValues(run.job.Name).Inc()
+	metricJobsRunning.Inc()
+
+	return nil
+}
+
+// abandonRun gives up the run never started, spooled events are kept to replay
+func (externalCmdService *ExternalCmdService) abandonRun(run *jobRun, err error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "abandonRun",
+	})
+
+	logger.WithFields(getRunLogFields(run)).Warn(err)
+
+	run.err = err
+	endSpanWithError(run.span, err)
+
+	externalCmdService.recordRunResult(run)
+
+	if len(run.partition) > 0 {
+		externalCmdService.releasePartition(run.partition)
+	}
+}
+
+// executeRun runs the job once rate limits allow, and reports the result
+func (externalCmdService *ExternalCmdService) executeRun(run *jobRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "executeRun",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer externalCmdService.jobWaitGroup.Done()
+
+	logger = logger.WithFields(getRunLogFields(run))
+
+	job := run.job
+
+	// the run is not running nor timed while delayed by rate limits
+	if !externalCmdService.waitRateLimits(run) {
+		externalCmdService.abandonRun(run, xerrors.Errorf("service is terminating, stop waiting for rate limits"))
+		return
+	}
+
+	err := externalCmdService.registerRun(run)
 	if err != nil {
-		logger.Error(err)
-		return err
+		externalCmdService.abandonRun(run, err)
+		return
+	}
+
+	run.startTime = time.Now()
+
+	jobLogService := externalCmdService.service.jobLogService
+	if job.OutputLog && jobLogService != nil {
+		runLog, err := jobLogService.Open(run)
+		if err != nil {
+			logger.WithError(err).Warn("failed to open job log, output is not logged")
+		} else {
+			run.outputLog = runLog
+		}
 	}
 
-	_, err = io.WriteString(childStdin, string(recordJson))
+	externalCmdService.executeAttempts(run)
+
+	run.endTime = time.Now()
+
+	externalCmdService.finishRun(run)
+}
+
+// executeAttempt runs an attempt of the run with the executor of the job's action
+func (externalCmdService *ExternalCmdService) executeAttempt(run *jobRun) error {
+	executor, err := externalCmdService.newJobExecutor(run.job)
 	if err != nil {
-		logger.WithError(err).Error("failed to send via STDIN")
 		return err
 	}
+	defer executor.release()
 
-	childStdin.Close()
+	// the result is from the last attempt
+	run.stdout.Reset()
+	run.result = nil
 
-	return nil
+	return executor.execute(run)
+}
+
+// finishRun reports the result of the run
+func (externalCmdService *ExternalCmdService) finishRun(run *jobRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "finishRun",
+	})
+
+	logger = logger.WithFields(getRunLogFields(run))
+
+	job := run.job
+	status := run.getStatus()
+
+	if run.outputLog != nil {
+		err := externalCmdService.service.jobLogService.Commit(run, run.outputLog)
+		if err != nil {
+			logger.WithError(err).Error("failed to write job log")
+		} else {
+			logger = logger.WithField(logFieldJobLog, run.outputLog.filePath)
+		}
+	}
+
+	metricJobsRunning.Dec()
+	metricJobDuration.WithLabelValues(job.Name).Observe(run.endTime.Sub(run.startTime).Seconds())
+
+	switch status {
+	case JobRunStatusSucceeded:
+		logger.Infof("job finished (attempts %d)", run.attempts)
+		metricJobsSucceeded.WithLabelValues(job.Name).Inc()
+	case JobRunStatusSkipped:
+		logger.Infof("job skipped (attempts %d)", run.attempts)
+		metricJobsSkipped.WithLabelValues(job.Name).Inc()
+	case JobRunStatusTimedOut:
+		logger.WithError(run.err).Errorf("job timed out (attempts %d)", run.attempts)
+		metricJobsTimedOut.WithLabelValues(job.Name).Inc()
+	default:
+		logger.WithError(run.err).Errorf("job failed (attempts %d)", run.attempts)
+		metricJobsFailed.WithLabelValues(job.Name).Inc()
+	}
+
+	run.span.SetAttributes(
+		attribute.Int("process.exit_code", run.exitCode),
+		attribute.Int("job.attempts", run.attempts),
+	)
+	endSpanWithError(run.span, run.err)
+
+	historyService := externalCmdService.service.historyService
+	if historyService != nil {
+		err := historyService.AddRecord(run.toRecord())
+		if err != nil {
+			logger.WithError(err).Error("failed to record job run history")
+		}
+	}
+
+	externalCmdService.markRunProcessed(run)
+
+	if run.attempts > 0 {
+		err := externalCmdService.publishCompletionEvent(run)
+		if err != nil {
+			logger.WithError(err).Error("failed to publish completion event")
+			metricCompletionPublishFailures.WithLabelValues(job.Name).Inc()
+		}
+	}
+
+	externalCmdService.runningJobsLock.Lock()
+	delete(externalCmdService.runningJobs, run.id)
+	externalCmdService.runningJobsLock.Unlock()
+
+	externalCmdService.recordRunResult(run)
+
+	if len(run.partition) > 0 {
+		externalCmdService.releasePartition(run.partition)
+	}
+
+	if run.attempts == 0 {
+		// never ran, keep spooled events to replay
+		return
+	}
+
+	externalCmdService.runChainedJobs(run)
+
+	releaseSpoolEntries(run.spoolEntries)
 }
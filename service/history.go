@@ -0,0 +1,401 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	JobRunStatusSucceeded string = "succeeded"
+	JobRunStatusFailed    string = "failed"
+	JobRunStatusTimedOut  string = "timed_out"
+	JobRunStatusSkipped   string = "skipped"
+
+	HistoryQueryLimitDefault int = 100
+
+	historyBucketName       string        = "runs"
+	historyOpenTimeout      time.Duration = 1 * time.Second
+	historyPruneInterval    time.Duration = 1 * time.Hour
+	historyTimeKeyLength    int           = 8
+	historyQueryTimeLayout  string        = time.RFC3339
+	historyQueryParamJob    string        = "job"
+	historyQueryParamBucket string        = "bucket"
+	historyQueryParamPrefix string        = "prefix"
+	historyQueryParamStatus string        = "status"
+	historyQueryParamSince  string        = "since"
+	historyQueryParamUntil  string        = "until"
+	historyQueryParamLimit  string        = "limit"
+)
+
+// JobRunRecord is a history entry of a job run
+type JobRunRecord struct {
+	RunID         string     `json:"run_id"`
+	Job           string     `json:"job"`
+	EventName     string     `json:"event_name"`
+	Bucket        string     `json:"bucket"`
+	Key           string     `json:"key"`
+	ETag          string     `json:"etag,omitempty"`
+	Sequencer     string     `json:"sequencer,omitempty"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       time.Time  `json:"end_time"`
+	Status        string     `json:"status"`
+	ExitCode      int        `json:"exit_code"`
+	StatusCode    int        `json:"status_code,omitempty"` // of http action
+	Attempts      int        `json:"attempts"`
+	Records       int        `json:"records,omitempty"`         // the number of records given to the run
+	PreviousRunID string     `json:"previous_run_id,omitempty"` // of the job chaining this run
+	Result        *JobResult `json:"result,omitempty"`          // reported by the job
+	Output        string     `json:"output,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// JobRunQuery is a filter for querying job run history
+type JobRunQuery struct {
+	Job       string
+	Bucket    string
+	KeyPrefix string
+	Status    string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// NewJobRunQueryFromValues creates JobRunQuery from URL query values
+func NewJobRunQueryFromValues(values url.Values) (*JobRunQuery, error) {
+	query := &JobRunQuery{
+		Job:       values.Get(historyQueryParamJob),
+		Bucket:    values.Get(historyQueryParamBucket),
+		KeyPrefix: values.Get(historyQueryParamPrefix),
+		Status:    values.Get(historyQueryParamStatus),
+		Limit:     HistoryQueryLimitDefault,
+	}
+
+	if since := values.Get(historyQueryParamSince); len(since) > 0 {
+		sinceTime, err := time.Parse(historyQueryTimeLayout, since)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse since %q: %w", since, err)
+		}
+		query.Since = sinceTime
+	}
+
+	if until := values.Get(historyQueryParamUntil); len(until) > 0 {
+		untilTime, err := time.Parse(historyQueryTimeLayout, until)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse until %q: %w", until, err)
+		}
+		query.Until = untilTime
+	}
+
+	if limit := values.Get(historyQueryParamLimit); len(limit) > 0 {
+		limitNum, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse limit %q: %w", limit, err)
+		}
+		query.Limit = limitNum
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *JobRunQuery) Values() url.Values {
+	values := url.Values{}
+
+	if len(query.Job) > 0 {
+		values.Set(historyQueryParamJob, query.Job)
+	}
+
+	if len(query.Bucket) > 0 {
+		values.Set(historyQueryParamBucket, query.Bucket)
+	}
+
+	if len(query.KeyPrefix) > 0 {
+		values.Set(historyQueryParamPrefix, query.KeyPrefix)
+	}
+
+	if len(query.Status) > 0 {
+		values.Set(historyQueryParamStatus, query.Status)
+	}
+
+	if !query.Since.IsZero() {
+		values.Set(historyQueryParamSince, query.Since.Format(historyQueryTimeLayout))
+	}
+
+	if !query.Until.IsZero() {
+		values.Set(historyQueryParamUntil, query.Until.Format(historyQueryTimeLayout))
+	}
+
+	if query.Limit > 0 {
+		values.Set(historyQueryParamLimit, strconv.Itoa(query.Limit))
+	}
+
+	return values
+}
+
+// Match checks if the record matches to the query
+func (query *JobRunQuery) Match(record *JobRunRecord) bool {
+	if len(query.Job) > 0 && query.Job != record.Job {
+		return false
+	}
+
+	if len(query.Bucket) > 0 && query.Bucket != record.Bucket {
+		return false
+	}
+
+	if len(query.KeyPrefix) > 0 && !strings.HasPrefix(record.Key, query.KeyPrefix) {
+		return false
+	}
+
+	if len(query.Status) > 0 && query.Status != record.Status {
+		return false
+	}
+
+	return true
+}
+
+// HistoryService keeps job run history in a local embedded store
+type HistoryService struct {
+	service       *S3DataWatcherService
+	db            *bolt.DB
+	retention     time.Duration
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// CreateHistoryService creates a History service object and opens the store
+func CreateHistoryService(service *S3DataWatcherService) (*HistoryService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateHistoryService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	historyFilePath := service.config.GetHistoryFilePath()
+
+	db, err := openHistoryDB(historyFilePath, false)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	historyService := &HistoryService{
+		service:       service,
+		db:            db,
+		retention:     service.config.GetHistoryRetention(),
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}
+
+	logger.Infof("recording job run history to %s", historyFilePath)
+
+	if historyService.retention > 0 {
+		historyService.waitGroup.Add(1)
+		go historyService.pruneLoop()
+	}
+
+	return historyService, nil
+}
+
+// Release releases all resources, closing the store
+func (historyService *HistoryService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "HistoryService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	close(historyService.terminateChan)
+	historyService.waitGroup.Wait()
+
+	err := historyService.db.Close()
+	if err != nil {
+		logger.WithError(err).Warn("failed to close history store")
+	}
+}
+
+// AddRecord adds a job run record
+func (historyService *HistoryService) AddRecord(record *JobRunRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return historyService.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucketName))
+		return bucket.Put(makeHistoryKey(record.StartTime, record.RunID), recordBytes)
+	})
+}
+
+// Query returns job run records matching to the query, newest first
+func (historyService *HistoryService) Query(query *JobRunQuery) ([]*JobRunRecord, error) {
+	return queryHistoryDB(historyService.db, query)
+}
+
+func (historyService *HistoryService) pruneLoop() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "HistoryService",
+		"function": "pruneLoop",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer historyService.waitGroup.Done()
+
+	for {
+		pruned, err := historyService.prune(time.Now().Add(-historyService.retention))
+		if err != nil {
+			logger.WithError(err).Warn("failed to prune job run history")
+		} else if pruned > 0 {
+			logger.Infof("pruned %d job run records", pruned)
+		}
+
+		select {
+		case <-historyService.terminateChan:
+			return
+		case <-time.After(historyPruneInterval):
+		}
+	}
+}
+
+// prune deletes records started before the given time
+func (historyService *HistoryService) prune(before time.Time) (int, error) {
+	pruned := 0
+	err := historyService.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(historyBucketName)).Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			if !getHistoryKeyTime(key).Before(before) {
+				break
+			}
+
+			err := cursor.Delete()
+			if err != nil {
+				return err
+			}
+			pruned++
+		}
+		return nil
+	})
+
+	return pruned, err
+}
+
+// QueryHistoryFile returns job run records in the given store file, newest first
+// used when the service is not running
+func QueryHistoryFile(historyFilePath string, query *JobRunQuery) ([]*JobRunRecord, error) {
+	db, err := openHistoryDB(historyFilePath, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return queryHistoryDB(db, query)
+}
+
+func openHistoryDB(historyFilePath string, readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(historyFilePath, 0644, &bolt.Options{
+		Timeout:  historyOpenTimeout,
+		ReadOnly: readOnly,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open history store %s: %w", historyFilePath, err)
+	}
+
+	if readOnly {
+		return db, nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(historyBucketName))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, xerrors.Errorf("failed to create history bucket: %w", err)
+	}
+
+	return db, nil
+}
+
+func queryHistoryDB(db *bolt.DB, query *JobRunQuery) ([]*JobRunRecord, error) {
+	records := []*JobRunRecord{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucketName))
+		if bucket == nil {
+			// empty store
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+
+		var key, value []byte
+		if query.Until.IsZero() {
+			key, value = cursor.Last()
+		} else {
+			// seek to the first key after until, then step back
+			untilKey := makeHistoryKey(query.Until.Add(time.Nanosecond), "")
+			key, value = cursor.Seek(untilKey)
+			if key == nil {
+				key, value = cursor.Last()
+			} else {
+				key, value = cursor.Prev()
+			}
+		}
+
+		for ; key != nil; key, value = cursor.Prev() {
+			if !query.Since.IsZero() && getHistoryKeyTime(key).Before(query.Since) {
+				break
+			}
+
+			record := JobRunRecord{}
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return xerrors.Errorf("failed to unmarshal job run record: %w", err)
+			}
+
+			if !query.Match(&record) {
+				continue
+			}
+
+			records = append(records, &record)
+			if query.Limit > 0 && len(records) >= query.Limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return records, err
+}
+
+// makeHistoryKey makes a key sorted by start time
+func makeHistoryKey(startTime time.Time, runID string) []byte {
+	key := bytes.Buffer{}
+	timeBytes := make([]byte, historyTimeKeyLength)
+	binary.BigEndian.PutUint64(timeBytes, uint64(startTime.UnixNano()))
+	key.Write(timeBytes)
+	key.WriteString(runID)
+	return key.Bytes()
+}
+
+func getHistoryKeyTime(key []byte) time.Time {
+	if len(key) < historyTimeKeyLength {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:historyTimeKeyLength])))
+}
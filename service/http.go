@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+const (
+	httpShutdownTimeout time.Duration = 5 * time.Second
+)
+
+const (
+	// HistoryAPIPath is the path of admin API for job run history
+	HistoryAPIPath string = "/api/v1/history"
+	// CircuitBreakersAPIPath is the path of admin API for circuit breakers of jobs
+	CircuitBreakersAPIPath string = "/api/v1/circuit_breakers"
+)
+
+// HTTPService serves HTTP endpoints such as metrics, health checks and admin APIs
+type HTTPService struct {
+	service *S3DataWatcherService
+	address string
+	server  *http.Server
+	// bearer token required by admin APIs, empty for no authentication
+	adminToken string
+}
+
+// CreateHTTPService creates a HTTP service object serving metrics and health checks and starts listening
+func CreateHTTPService(service *S3DataWatcherService, address string) (*HTTPService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateHTTPService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	httpService := &HTTPService{
+		service: service,
+		address: address,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", httpService.handleHealthz)
+	mux.HandleFunc("/readyz", httpService.handleReadyz)
+
+	err := httpService.serve(mux)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	logger.Infof("serving HTTP endpoints on %s", address)
+
+	return httpService, nil
+}
+
+// CreateAdminHTTPService creates a HTTP service object serving admin APIs and starts listening
+func CreateAdminHTTPService(service *S3DataWatcherService, address string, adminToken string) (*HTTPService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateAdminHTTPService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	httpService := &HTTPService{
+		service:    service,
+		address:    address,
+		adminToken: adminToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HistoryAPIPath, httpService.handleHistory)
+	mux.HandleFunc(CircuitBreakersAPIPath, httpService.handleCircuitBreakers)
+
+	err := httpService.serve(httpService.requireAdminToken(mux))
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(adminToken) == 0 {
+		logger.Warnf("serving admin APIs on %s without authentication", address)
+	} else {
+		logger.Infof("serving admin APIs on %s", address)
+	}
+
+	return httpService, nil
+}
+
+// serve starts listening and serving the handler in background
+func (httpService *HTTPService) serve(handler http.Handler) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "HTTPService",
+		"function": "serve",
+	})
+
+	httpService.server = &http.Server{
+		Addr:              httpService.address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", httpService.address)
+	if err != nil {
+		return xerrors.Errorf("failed to listen on %q: %w", httpService.address, err)
+	}
+
+	go func() {
+		serveErr := httpService.server.Serve(listener)
+		if serveErr != nil && serveErr != http.ErrServerClosed {
+			logger.WithError(serveErr).Error("failed to serve HTTP endpoints")
+		}
+	}()
+
+	return nil
+}
+
+// requireAdminToken rejects requests without the admin token as a bearer token
+func (httpService *HTTPService) requireAdminToken(handler http.Handler) http.Handler {
+	if len(httpService.adminToken) == 0 {
+		return handler
+	}
+
+	expected := []byte("Bearer " + httpService.adminToken)
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		authorization := []byte(request.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(authorization, expected) != 1 {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// handleHealthz reports liveness
+func (httpService *HTTPService) handleHealthz(writer http.ResponseWriter, request *http.Request) {
+	err := httpService.service.IsAlive()
+	writeProbeResult(writer, err)
+}
+
+// handleReadyz reports readiness
+func (httpService *HTTPService) handleReadyz(writer http.ResponseWriter, request *http.Request) {
+	err := httpService.service.IsReady()
+	writeProbeResult(writer, err)
+}
+
+// handleHistory returns job run history matching to the query
+func (httpService *HTTPService) handleHistory(writer http.ResponseWriter, request *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "HTTPService",
+		"function": "handleHistory",
+	})
+
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	historyService := httpService.service.historyService
+	if historyService == nil {
+		http.Error(writer, "history is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	query, err := NewJobRunQueryFromValues(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := historyService.Query(query)
+	if err != nil {
+		logger.WithError(err).Error("failed to query job run history")
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(records)
+	if err != nil {
+		logger.WithError(err).Warn("failed to write job run history")
+	}
+}
+
+// handleCircuitBreakers returns states of circuit breakers of jobs
+func (httpService *HTTPService) handleCircuitBreakers(writer http.ResponseWriter, request *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "HTTPService",
+		"function": "handleCircuitBreakers",
+	})
+
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	externalCmdService := httpService.service.getExternalCmdService()
+	if externalCmdService == nil {
+		http.Error(writer, "circuit breakers are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(writer).Encode(externalCmdService.GetCircuitBreakers())
+	if err != nil {
+		logger.WithError(err).Warn("failed to write circuit breakers")
+	}
+}
+
+func writeProbeResult(writer http.ResponseWriter, err error) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprintln(writer, "ok")
+}
+
+// Release releases all resources, stopping the HTTP server
+func (httpService *HTTPService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "HTTPService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	err := httpService.server.Shutdown(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("failed to shutdown HTTP server gracefully")
+	}
+}
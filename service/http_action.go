@@ -0,0 +1,225 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/xerrors"
+)
+
+// httpExecutor sends the payload to a web service
+type httpExecutor struct {
+	externalCmdService *ExternalCmdService
+	action             *JobHTTPAction
+	client             *http.Client
+}
+
+// httpActionClient is a client of a job's http action, reused while the TLS config of the job is the same
+type httpActionClient struct {
+	tls    JobHTTPTLS // zero if not given
+	client *http.Client
+}
+
+func newHTTPExecutor(externalCmdService *ExternalCmdService, job *Job) (*httpExecutor, error) {
+	client, err := externalCmdService.getHTTPActionClient(job)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpExecutor{
+		externalCmdService: externalCmdService,
+		action:             job.HTTP,
+		client:             client,
+	}, nil
+}
+
+// getHTTPActionClient returns the client of the job's http action, builds new one if the TLS config changes
+func (externalCmdService *ExternalCmdService) getHTTPActionClient(job *Job) (*http.Client, error) {
+	tlsConfigKey := JobHTTPTLS{}
+	if job.HTTP.TLS != nil {
+		tlsConfigKey = *job.HTTP.TLS
+	}
+
+	externalCmdService.httpActionClientsLock.Lock()
+	defer externalCmdService.httpActionClientsLock.Unlock()
+
+	if actionClient, ok := externalCmdService.httpActionClients[job.Name]; ok {
+		if actionClient.tls == tlsConfigKey {
+			return actionClient.client, nil
+		}
+
+		// changed
+		actionClient.client.CloseIdleConnections()
+		delete(externalCmdService.httpActionClients, job.Name)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if job.HTTP.TLS != nil {
+		tlsConfig, err := newHTTPActionTLSConfig(job.HTTP.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	externalCmdService.httpActionClients[job.Name] = &httpActionClient{
+		tls:    tlsConfigKey,
+		client: client,
+	}
+
+	return client, nil
+}
+
+// closeHTTPActionClients closes idle connections of clients of http actions
+func (externalCmdService *ExternalCmdService) closeHTTPActionClients() {
+	externalCmdService.httpActionClientsLock.Lock()
+	defer externalCmdService.httpActionClientsLock.Unlock()
+
+	for _, actionClient := range externalCmdService.httpActionClients {
+		actionClient.client.CloseIdleConnections()
+	}
+
+	externalCmdService.httpActionClients = map[string]*httpActionClient{}
+}
+
+func newHTTPActionTLSConfig(config *JobHTTPTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if len(config.CAFile) > 0 {
+		caBytes, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read ca file %s: %w", config.CAFile, err)
+		}
+
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caBytes) {
+			return nil, xerrors.Errorf("failed to parse ca file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if len(config.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load client cert %s: %w", config.CertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (executor *httpExecutor) release() {
+	// the client is reused by next runs
+}
+
+// execute sends a request, the response body can report the result of the run like stdout of commands
+func (executor *httpExecutor) execute(run *jobRun) error {
+	err := executor.send(run)
+	return applyJobResult(run, err, nil)
+}
+
+// send sends a request and reads the response, the response body is the output of the run
+func (executor *httpExecutor) send(run *jobRun) error {
+	externalCmdService := executor.externalCmdService
+	job := run.job
+	action := executor.action
+
+	body := run.payload
+	if len(action.Body) > 0 {
+		renderedBody, err := renderActionTemplate("body", action.Body, run)
+		if err != nil {
+			return err
+		}
+		body = []byte(renderedBody)
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if job.GetTimeout() > 0 {
+		ctx, cancel = context.WithTimeout(run.ctx, job.GetTimeout())
+	} else {
+		ctx, cancel = context.WithCancel(run.ctx)
+	}
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, action.GetMethod(), action.URL, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to make http request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", getPayloadContentType(job))
+	for key, value := range action.Headers {
+		request.Header.Set(key, os.ExpandEnv(value))
+	}
+
+	if action.Auth != nil {
+		if len(action.Auth.Token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+os.ExpandEnv(action.Auth.Token))
+		} else if len(action.Auth.Username) > 0 {
+			request.SetBasicAuth(os.ExpandEnv(action.Auth.Username), os.ExpandEnv(action.Auth.Password))
+		}
+	}
+
+	// pass trace context to the web service
+	otel.GetTextMapPropagator().Inject(run.ctx, propagation.HeaderCarrier(request.Header))
+
+	externalCmdService.runningJobsLock.Lock()
+	run.cancel = cancel
+	run.statusCode = 0
+	atomic.StoreInt32(&run.timedOut, 0)
+	externalCmdService.runningJobsLock.Unlock()
+
+	defer func() {
+		externalCmdService.runningJobsLock.Lock()
+		run.cancel = nil
+		externalCmdService.runningJobsLock.Unlock()
+	}()
+
+	response, err := executor.client.Do(request)
+	if err == nil {
+		defer response.Body.Close()
+
+		run.statusCode = response.StatusCode
+		_, err = io.Copy(io.MultiWriter(getRunOutput(run), run.stdout), response.Body)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		atomic.StoreInt32(&run.timedOut, 1)
+		return xerrors.Errorf("job timed out after %d seconds: %w", job.Timeout, err)
+	}
+
+	if err != nil {
+		return xerrors.Errorf("failed to send http request to %s: %w", action.URL, err)
+	}
+
+	if !action.IsSuccessStatus(response.StatusCode) {
+		return xerrors.Errorf("http request to %s failed with status %q", action.URL, response.Status)
+	}
+
+	run.exitCode = 0
+	return nil
+}
+
+// getPayloadContentType returns the content type of payloads of the job
+func getPayloadContentType(job *Job) string {
+	if job.Batch != nil && job.Batch.Format == JobBatchFormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		status        int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"not bearer", "secret", "secret", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			httpService := &HTTPService{
+				adminToken: test.adminToken,
+			}
+
+			request := httptest.NewRequest(http.MethodGet, HistoryAPIPath, nil)
+			if len(test.authorization) > 0 {
+				request.Header.Set("Authorization", test.authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+			httpService.requireAdminToken(handler).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
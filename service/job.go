@@ -0,0 +1,842 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	JobTriggerEvent   string = "event"
+	JobTriggerDataset string = "dataset"
+	JobTriggerChain   string = "chain"
+
+	JobBatchFormatJSON   string = "json"
+	JobBatchFormatNDJSON string = "ndjson"
+
+	JobActionCommand string = "command"
+	JobActionHTTP    string = "http"
+	JobActionNats    string = "nats"
+)
+
+type Filter struct {
+	Events  []string `yaml:"events,omitempty"`
+	Buckets []string `yaml:"buckets,omitempty"`
+	Objects []string `yaml:"objects,omitempty"`
+}
+
+// JobBatch accumulates matching records and sends them to a single job run
+// the batch is flushed when it reaches max events or max bytes, or max wait passes since the first record
+type JobBatch struct {
+	// 0 = no limit
+	MaxEvents int `yaml:"max_events,omitempty"`
+	// seconds
+	MaxWait int `yaml:"max_wait"`
+	// bytes of encoded records, 0 = no limit
+	MaxBytes int `yaml:"max_bytes,omitempty"`
+	// json (array) or ndjson
+	Format string `yaml:"format,omitempty"`
+}
+
+// GetMaxWait returns max wait of the batch
+func (batch *JobBatch) GetMaxWait() time.Duration {
+	return time.Duration(batch.MaxWait) * time.Second
+}
+
+// Validate validates the batch
+func (batch *JobBatch) Validate() error {
+	if batch.MaxWait <= 0 {
+		return xerrors.Errorf("batch max wait must be positive")
+	}
+
+	if batch.MaxEvents < 0 || batch.MaxBytes < 0 {
+		return xerrors.Errorf("batch max events and max bytes must not be negative")
+	}
+
+	switch batch.Format {
+	case "", JobBatchFormatJSON, JobBatchFormatNDJSON:
+	default:
+		return xerrors.Errorf("unknown batch format %q", batch.Format)
+	}
+
+	return nil
+}
+
+// JobDataset groups records of a dataset to run the job once the whole dataset arrives
+type JobDataset struct {
+	// regex matched to object keys, the first capture group identifies the dataset, e.g. ^(runs/[^/]+)/
+	Pattern string `yaml:"pattern"`
+	// seconds without new records to consider the dataset complete, 0 = wait for the marker
+	QuietPeriod int `yaml:"quiet_period,omitempty"`
+	// name of the object that marks the dataset complete, e.g. _COMPLETE
+	Marker string `yaml:"marker,omitempty"`
+	// seconds from the first record to run the dataset even if not complete, 0 = 86400
+	MaxAge int `yaml:"max_age,omitempty"`
+
+	// compiled pattern, set by Validate
+	patternRegexp *regexp.Regexp
+}
+
+// GetQuietPeriod returns quiet period of the dataset
+func (dataset *JobDataset) GetQuietPeriod() time.Duration {
+	return time.Duration(dataset.QuietPeriod) * time.Second
+}
+
+// GetMaxAge returns how long the dataset waits for completion at most
+func (dataset *JobDataset) GetMaxAge() time.Duration {
+	if dataset.MaxAge <= 0 {
+		return time.Duration(commons.DatasetMaxAgeDefault) * time.Second
+	}
+	return time.Duration(dataset.MaxAge) * time.Second
+}
+
+// Validate validates the dataset and compiles the pattern
+func (dataset *JobDataset) Validate() error {
+	pattern, err := regexp.Compile(dataset.Pattern)
+	if err != nil {
+		return xerrors.Errorf("failed to compile dataset pattern %q: %w", dataset.Pattern, err)
+	}
+
+	if pattern.NumSubexp() < 1 {
+		return xerrors.Errorf("dataset pattern %q must have a capture group", dataset.Pattern)
+	}
+
+	if dataset.QuietPeriod < 0 {
+		return xerrors.Errorf("dataset quiet period must not be negative")
+	}
+
+	if dataset.QuietPeriod == 0 && len(dataset.Marker) == 0 {
+		return xerrors.Errorf("dataset quiet period or marker must be given")
+	}
+
+	if dataset.MaxAge < 0 {
+		return xerrors.Errorf("dataset max age must not be negative")
+	}
+
+	dataset.patternRegexp = pattern
+	return nil
+}
+
+// JobOrdering serializes runs of the job per partition, runs of other partitions still run in parallel
+type JobOrdering struct {
+	// regex matched to object keys, the first capture group and the bucket make the partition key
+	// empty = bucket and object key
+	PartitionPattern string `yaml:"partition_pattern,omitempty"`
+	// seconds to remember the last sequencer of an object to drop older events, 0 = 86400
+	SequencerTTL int `yaml:"sequencer_ttl,omitempty"`
+
+	// compiled partition pattern, set by Validate
+	partitionRegexp *regexp.Regexp
+}
+
+// GetSequencerTTL returns how long the last sequencer of an object is remembered
+func (ordering *JobOrdering) GetSequencerTTL() time.Duration {
+	if ordering.SequencerTTL <= 0 {
+		return time.Duration(commons.OrderingSequencerTTLDefault) * time.Second
+	}
+	return time.Duration(ordering.SequencerTTL) * time.Second
+}
+
+// Validate validates the ordering and compiles the partition pattern
+func (ordering *JobOrdering) Validate() error {
+	if ordering.SequencerTTL < 0 {
+		return xerrors.Errorf("sequencer ttl must not be negative")
+	}
+
+	if len(ordering.PartitionPattern) == 0 {
+		return nil
+	}
+
+	pattern, err := regexp.Compile(ordering.PartitionPattern)
+	if err != nil {
+		return xerrors.Errorf("failed to compile partition pattern %q: %w", ordering.PartitionPattern, err)
+	}
+
+	if pattern.NumSubexp() < 1 {
+		return xerrors.Errorf("partition pattern %q must have a capture group", ordering.PartitionPattern)
+	}
+
+	ordering.partitionRegexp = pattern
+	return nil
+}
+
+// JobHTTPAction sends the payload to a web service
+type JobHTTPAction struct {
+	URL string `yaml:"url"`
+	// POST if empty
+	Method  string            `yaml:"method,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Go template of the request body, empty = the payload sent to commands via STDIN
+	Body string `yaml:"body,omitempty"`
+
+	Auth *JobHTTPAuth `yaml:"auth,omitempty"`
+	TLS  *JobHTTPTLS  `yaml:"tls,omitempty"`
+
+	// status codes considered success, empty = 2xx
+	SuccessStatus []int `yaml:"success_status,omitempty"`
+}
+
+// JobHTTPAuth is credentials of the web service, environment variables such as ${API_TOKEN} are expanded
+type JobHTTPAuth struct {
+	// basic auth
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// bearer token
+	Token string `yaml:"token,omitempty"`
+}
+
+// JobHTTPTLS is TLS config of the web service
+type JobHTTPTLS struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// GetMethod returns HTTP method of the action
+func (action *JobHTTPAction) GetMethod() string {
+	if len(action.Method) == 0 {
+		return http.MethodPost
+	}
+	return strings.ToUpper(action.Method)
+}
+
+// IsSuccessStatus returns true if the status code is considered success
+func (action *JobHTTPAction) IsSuccessStatus(statusCode int) bool {
+	if len(action.SuccessStatus) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+
+	for _, successStatus := range action.SuccessStatus {
+		if statusCode == successStatus {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate validates the HTTP action
+func (action *JobHTTPAction) Validate() error {
+	actionURL, err := url.Parse(action.URL)
+	if err != nil {
+		return xerrors.Errorf("failed to parse url %q: %w", action.URL, err)
+	}
+
+	if actionURL.Scheme != "http" && actionURL.Scheme != "https" {
+		return xerrors.Errorf("url %q must be http or https", action.URL)
+	}
+
+	if len(action.Body) > 0 {
+		_, err = parseActionTemplate("body", action.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	if action.Auth != nil && len(action.Auth.Username) > 0 && len(action.Auth.Token) > 0 {
+		return xerrors.Errorf("auth cannot have both username and token")
+	}
+
+	if action.TLS != nil && (len(action.TLS.CertFile) > 0) != (len(action.TLS.KeyFile) > 0) {
+		return xerrors.Errorf("tls cert file and key file must be given together")
+	}
+
+	for _, successStatus := range action.SuccessStatus {
+		if successStatus < 100 || successStatus > 599 {
+			return xerrors.Errorf("invalid success status %d", successStatus)
+		}
+	}
+
+	return nil
+}
+
+// JobNatsAction publishes the payload to a Nats subject
+type JobNatsAction struct {
+	// Go template, e.g. pipeline.{{ .Bucket }}.created
+	Subject string `yaml:"subject"`
+	// values are Go templates
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Go template of the message, empty = the payload sent to commands via STDIN
+	Payload string `yaml:"payload,omitempty"`
+}
+
+// Validate validates the Nats action
+func (action *JobNatsAction) Validate() error {
+	if len(strings.TrimSpace(action.Subject)) == 0 {
+		return xerrors.Errorf("subject must be given")
+	}
+
+	_, err := parseActionTemplate("subject", action.Subject)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range action.Headers {
+		_, err = parseActionTemplate("header "+key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(action.Payload) > 0 {
+		_, err = parseActionTemplate("payload", action.Payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// JobCircuitBreaker pauses the job after consecutive failures, events are parked until a probe run succeeds
+type JobCircuitBreaker struct {
+	// consecutive failed runs to open the breaker
+	Threshold int `yaml:"threshold"`
+	// seconds to wait before a probe run, 0 = 60
+	Cooldown int `yaml:"cooldown,omitempty"`
+}
+
+// GetCooldown returns cooldown of the circuit breaker
+func (circuitBreaker *JobCircuitBreaker) GetCooldown() time.Duration {
+	if circuitBreaker.Cooldown <= 0 {
+		return time.Duration(commons.CircuitBreakerCooldownDefault) * time.Second
+	}
+	return time.Duration(circuitBreaker.Cooldown) * time.Second
+}
+
+// Validate validates the circuit breaker
+func (circuitBreaker *JobCircuitBreaker) Validate() error {
+	if circuitBreaker.Threshold < 1 {
+		return xerrors.Errorf("circuit breaker threshold must be positive")
+	}
+
+	if circuitBreaker.Cooldown < 0 {
+		return xerrors.Errorf("circuit breaker cooldown must not be negative")
+	}
+
+	return nil
+}
+
+type Job struct {
+	// unique name, used in logs, metrics and history
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	// nil = enabled
+	Enabled *bool    `yaml:"enabled,omitempty"`
+	Tags    []string `yaml:"tags,omitempty"`
+	Owner   string   `yaml:"owner,omitempty"`
+
+	// command (default) runs a local executable, http sends the payload to a web service,
+	// nats publishes the payload to a Nats subject
+	Action  string         `yaml:"action,omitempty"`
+	Command string         `yaml:"command,omitempty"`
+	HTTP    *JobHTTPAction `yaml:"http,omitempty"`
+	Nats    *JobNatsAction `yaml:"nats,omitempty"`
+	Filter  Filter         `yaml:"filter,omitempty"`
+
+	// event (default) runs the job for each record, dataset runs the job once for a dataset,
+	// chain runs the job only after other jobs listing it in on_success or on_failure
+	Trigger string      `yaml:"trigger,omitempty"`
+	Dataset *JobDataset `yaml:"dataset,omitempty"`
+
+	// seconds, kill the job if it runs longer than this, 0 = no timeout
+	Timeout int `yaml:"timeout,omitempty"`
+
+	// retry failed job, 0 or 1 = no retry
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// seconds to wait between attempts
+	RetryInterval int `yaml:"retry_interval,omitempty"`
+
+	// seconds, events for the same object within this window run the job once with the latest event, 0 = no debounce
+	Debounce int `yaml:"debounce,omitempty"`
+
+	// delay runs over the rate, nil = no limit
+	RateLimit *commons.RateLimitConfig `yaml:"rate_limit,omitempty"`
+
+	// send many records to a single run, nil = a run per record
+	Batch *JobBatch `yaml:"batch,omitempty"`
+
+	// run one at a time per object or partition in sequencer order, nil = no ordering
+	Ordering *JobOrdering `yaml:"ordering,omitempty"`
+
+	// park events after consecutive failures, nil = always run
+	CircuitBreaker *JobCircuitBreaker `yaml:"circuit_breaker,omitempty"`
+
+	// Go template of the subject to publish completion events of the job, overrides completion_subject of nats_config
+	CompletionSubject string `yaml:"completion_subject,omitempty"`
+
+	// names of jobs to run after the job succeeds or fails, with the records and the result of the job
+	OnSuccess []string `yaml:"on_success,omitempty"`
+	OnFailure []string `yaml:"on_failure,omitempty"`
+
+	// write stdout and stderr to the job's own log file
+	OutputLog bool `yaml:"output_log,omitempty"`
+
+	// where the job is defined, for error messages
+	sourceFile  string
+	sourceIndex int
+}
+
+// GetAction returns action type of the job
+func (job *Job) GetAction() string {
+	if len(job.Action) == 0 {
+		return JobActionCommand
+	}
+	return job.Action
+}
+
+// IsEnabled returns true if the job accepts events
+func (job *Job) IsEnabled() bool {
+	return job.Enabled == nil || *job.Enabled
+}
+
+// GetTimeout returns timeout of the job
+func (job *Job) GetTimeout() time.Duration {
+	return time.Duration(job.Timeout) * time.Second
+}
+
+// getChainedJobNames returns names of jobs chained after the job
+func (job *Job) getChainedJobNames() []string {
+	names := []string{}
+	names = append(names, job.OnSuccess...)
+	names = append(names, job.OnFailure...)
+	return names
+}
+
+// getSource returns where the job is defined
+func (job *Job) getSource() string {
+	return fmt.Sprintf("%s (job #%d)", job.sourceFile, job.sourceIndex+1)
+}
+
+// GetDebounce returns debounce window of the job
+func (job *Job) GetDebounce() time.Duration {
+	return time.Duration(job.Debounce) * time.Second
+}
+
+// Validate validates the job
+func (job *Job) Validate() error {
+	if len(strings.TrimSpace(job.Name)) == 0 {
+		return xerrors.Errorf("job name must be given")
+	}
+
+	switch job.GetAction() {
+	case JobActionCommand:
+		if len(job.Command) == 0 {
+			return xerrors.Errorf("job %q must have a command", job.Name)
+		}
+	case JobActionHTTP:
+		if job.HTTP == nil {
+			return xerrors.Errorf("job %q must have http for http action", job.Name)
+		}
+
+		err := job.HTTP.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid http action: %w", job.Name, err)
+		}
+	case JobActionNats:
+		if job.Nats == nil {
+			return xerrors.Errorf("job %q must have nats for nats action", job.Name)
+		}
+
+		err := job.Nats.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid nats action: %w", job.Name, err)
+		}
+	default:
+		return xerrors.Errorf("job %q has unknown action %q", job.Name, job.Action)
+	}
+
+	if len(job.Command) > 0 && job.GetAction() != JobActionCommand {
+		return xerrors.Errorf("job %q cannot have a command for %s action", job.Name, job.GetAction())
+	}
+
+	if job.HTTP != nil && job.GetAction() != JobActionHTTP {
+		return xerrors.Errorf("job %q cannot have http for %s action", job.Name, job.GetAction())
+	}
+
+	if job.Nats != nil && job.GetAction() != JobActionNats {
+		return xerrors.Errorf("job %q cannot have nats for %s action", job.Name, job.GetAction())
+	}
+
+	if job.Timeout < 0 {
+		return xerrors.Errorf("job %q timeout must not be negative", job.Name)
+	}
+
+	if job.Debounce < 0 {
+		return xerrors.Errorf("job %q debounce must not be negative", job.Name)
+	}
+
+	switch job.Trigger {
+	case "", JobTriggerEvent:
+	case JobTriggerDataset:
+		if job.Dataset == nil {
+			return xerrors.Errorf("job %q must have dataset for dataset trigger", job.Name)
+		}
+
+		err := job.Dataset.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid dataset: %w", job.Name, err)
+		}
+
+		if job.Batch != nil || job.Debounce > 0 || job.Ordering != nil {
+			return xerrors.Errorf("job %q cannot use batch, debounce or ordering with dataset trigger", job.Name)
+		}
+	case JobTriggerChain:
+		if job.Dataset != nil || job.Batch != nil || job.Debounce > 0 || job.Ordering != nil {
+			return xerrors.Errorf("job %q cannot use dataset, batch, debounce or ordering with chain trigger", job.Name)
+		}
+	default:
+		return xerrors.Errorf("job %q has unknown trigger %q", job.Name, job.Trigger)
+	}
+
+	if job.RateLimit != nil {
+		err := job.RateLimit.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid rate limit: %w", job.Name, err)
+		}
+	}
+
+	if job.Batch != nil {
+		err := job.Batch.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid batch: %w", job.Name, err)
+		}
+	}
+
+	if job.Ordering != nil {
+		if job.Batch != nil {
+			return xerrors.Errorf("job %q cannot use ordering with batch", job.Name)
+		}
+
+		err := job.Ordering.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid ordering: %w", job.Name, err)
+		}
+	}
+
+	if len(job.CompletionSubject) > 0 {
+		_, err := parseActionTemplate("completion subject", job.CompletionSubject)
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid completion subject: %w", job.Name, err)
+		}
+	}
+
+	if job.CircuitBreaker != nil {
+		err := job.CircuitBreaker.Validate()
+		if err != nil {
+			return xerrors.Errorf("job %q has invalid circuit breaker: %w", job.Name, err)
+		}
+	}
+
+	err := job.validateRetry()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type Jobs struct {
+	// other job files to merge, relative to the dir of this file, glob patterns are allowed
+	Include []string `yaml:"include,omitempty"`
+	Jobs    []Job    `yaml:"jobs"`
+}
+
+// Validate validates jobs, job names must be unique
+func (jobs *Jobs) Validate() error {
+	names := map[string]*Job{}
+	for jobIdx := range jobs.Jobs {
+		job := &jobs.Jobs[jobIdx]
+
+		err := job.Validate()
+		if err != nil {
+			return xerrors.Errorf("invalid job in %s: %w", job.getSource(), err)
+		}
+
+		if prevJob, ok := names[job.Name]; ok {
+			return xerrors.Errorf("duplicate job name %q in %s and %s", job.Name, prevJob.getSource(), job.getSource())
+		}
+		names[job.Name] = job
+	}
+
+	for jobIdx := range jobs.Jobs {
+		job := &jobs.Jobs[jobIdx]
+
+		for _, next := range job.getChainedJobNames() {
+			nextJob, ok := names[next]
+			if !ok {
+				return xerrors.Errorf("job %q in %s chains unknown job %q", job.Name, job.getSource(), next)
+			}
+
+			// chained runs start right after the run, not through the dispatch of events
+			if nextJob.Trigger == JobTriggerDataset || nextJob.Batch != nil || nextJob.Debounce > 0 || nextJob.Ordering != nil {
+				return xerrors.Errorf("job %q in %s chains job %q using dataset trigger, batch, debounce or ordering, which chained runs do not apply", job.Name, job.getSource(), next)
+			}
+		}
+	}
+
+	return jobs.checkChainCycles(names)
+}
+
+// checkChainCycles returns an error if jobs chain back to themselves through on_success or on_failure
+func (jobs *Jobs) checkChainCycles(names map[string]*Job) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	states := map[string]int{}
+	path := []string{}
+
+	var visit func(job *Job) error
+	visit = func(job *Job) error {
+		switch states[job.Name] {
+		case visiting:
+			// path from the job to here is the cycle
+			for idx, name := range path {
+				if name == job.Name {
+					return xerrors.Errorf("job chain cycle detected: %s -> %s", strings.Join(path[idx:], " -> "), job.Name)
+				}
+			}
+			return xerrors.Errorf("job chain cycle detected at job %q", job.Name)
+		case visited:
+			return nil
+		}
+
+		states[job.Name] = visiting
+		path = append(path, job.Name)
+
+		for _, next := range job.getChainedJobNames() {
+			nextJob, ok := names[next]
+			if !ok {
+				return xerrors.Errorf("job %q chains unknown job %q", job.Name, next)
+			}
+
+			err := visit(nextJob)
+			if err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		states[job.Name] = visited
+		return nil
+	}
+
+	for jobIdx := range jobs.Jobs {
+		err := visit(&jobs.Jobs[jobIdx])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetJob returns the job of the name, nil if not found
+func (jobs *Jobs) GetJob(name string) *Job {
+	for jobIdx := range jobs.Jobs {
+		if jobs.Jobs[jobIdx].Name == name {
+			return &jobs.Jobs[jobIdx]
+		}
+	}
+	return nil
+}
+
+// LoadJobs reads jobs from the job file, files it includes and files in the job dir,
+// and merges them into a validated job set
+// the job file may not exist if the job dir is given
+func LoadJobs(jobFilePath string, jobDirPath string) (*Jobs, error) {
+	loader := newJobLoader()
+
+	if len(jobFilePath) > 0 {
+		_, err := os.Stat(jobFilePath)
+		if err == nil || len(jobDirPath) == 0 {
+			err = loader.loadFile(jobFilePath)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(jobDirPath) > 0 {
+		jobFilePaths, err := listJobDir(jobDirPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range jobFilePaths {
+			err = loader.loadFile(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	jobs := &Jobs{
+		Jobs: loader.jobs,
+	}
+
+	err := jobs.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// listJobDir returns job files (*.yaml, *.yml) in the dir, or files matching the glob pattern
+// (e.g. /etc/s3_data_watcher/jobs.d/*.yaml), sorted by name
+func listJobDir(jobDirPath string) ([]string, error) {
+	if strings.ContainsAny(jobDirPath, "*?[") {
+		return globJobDir(jobDirPath)
+	}
+
+	entries, err := os.ReadDir(jobDirPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read job dir %s: %w", jobDirPath, err)
+	}
+
+	paths := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml":
+			paths = append(paths, filepath.Join(jobDirPath, entry.Name()))
+		}
+	}
+
+	// ReadDir returns entries sorted by filename
+	return paths, nil
+}
+
+// globJobDir returns files matching the job dir glob pattern, the pattern may match no files
+func globJobDir(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to match job dir pattern %q: %w", pattern, err)
+	}
+
+	paths := []string{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to stat job file %s: %w", match, err)
+		}
+
+		if info.IsDir() {
+			continue
+		}
+
+		paths = append(paths, match)
+	}
+
+	// Glob returns matches sorted by name
+	return paths, nil
+}
+
+// jobLoader reads job files following includes
+type jobLoader struct {
+	jobs    []Job
+	loaded  map[string]bool // files loaded, to read each file once
+	loading map[string]bool // files being loaded, to detect include cycles
+}
+
+func newJobLoader() *jobLoader {
+	return &jobLoader{
+		jobs:    []Job{},
+		loaded:  map[string]bool{},
+		loading: map[string]bool{},
+	}
+}
+
+func (loader *jobLoader) loadFile(jobFilePath string) error {
+	absPath, err := filepath.Abs(jobFilePath)
+	if err != nil {
+		return xerrors.Errorf("failed to get absolute path of %s: %w", jobFilePath, err)
+	}
+
+	if loader.loading[absPath] {
+		return xerrors.Errorf("include cycle detected at job file %s", jobFilePath)
+	}
+
+	if loader.loaded[absPath] {
+		return nil
+	}
+
+	jobData, err := os.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+
+	var jobs Jobs
+	err = yaml.Unmarshal(jobData, &jobs)
+	if err != nil {
+		return xerrors.Errorf("failed to parse job file %s: %w", jobFilePath, err)
+	}
+
+	for jobIdx := range jobs.Jobs {
+		job := jobs.Jobs[jobIdx]
+		job.sourceFile = jobFilePath
+		job.sourceIndex = jobIdx
+		loader.jobs = append(loader.jobs, job)
+	}
+
+	loader.loaded[absPath] = true
+	loader.loading[absPath] = true
+	defer delete(loader.loading, absPath)
+
+	for _, include := range jobs.Include {
+		includePaths, err := resolveJobInclude(filepath.Dir(jobFilePath), include)
+		if err != nil {
+			return xerrors.Errorf("failed to include %q in %s: %w", include, jobFilePath, err)
+		}
+
+		for _, includePath := range includePaths {
+			err = loader.loadFile(includePath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveJobInclude returns job file paths of the include, relative to the dir of the including file
+// a glob pattern may match no files, but a plain path must exist
+func resolveJobInclude(baseDirPath string, include string) ([]string, error) {
+	includePath, err := commons.ExpandHomeDir(include)
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(includePath) {
+		includePath = filepath.Join(baseDirPath, includePath)
+	}
+
+	if !strings.ContainsAny(includePath, "*?[") {
+		return []string{includePath}, nil
+	}
+
+	matches, err := filepath.Glob(includePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Glob returns matches sorted by name
+	return matches, nil
+}
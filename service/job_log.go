@@ -0,0 +1,283 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+const (
+	jobLogFileExt         string        = ".log"
+	jobLogDateLayout      string        = "2006-01-02"
+	jobLogTimeLayout      string        = time.RFC3339Nano
+	jobLogTempFilePattern string        = ".run-*"
+	jobLogPruneInterval   time.Duration = 1 * time.Hour
+)
+
+// JobLogService writes job output to per-job log files, DataRootPath/jobs/<job>/<date>.log
+// output of a run is collected in a temp file and appended as a whole when the run finishes,
+// so runs of the same job running at the same time are not interleaved
+type JobLogService struct {
+	service       *S3DataWatcherService
+	dirPath       string
+	retention     time.Duration
+	appendLock    sync.Mutex
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+
+	// temp files of running jobs, not pruned even if not modified for long
+	runLogFiles     map[string]bool
+	runLogFilesLock sync.Mutex
+}
+
+// jobRunLog collects output of a job run
+type jobRunLog struct {
+	jobDirPath string
+	file       *os.File
+	filePath   string // log file the output is appended to, set when committed
+
+	// to end output with a newline before writing delimiters
+	endsWithNewline bool
+}
+
+// CreateJobLogService creates a JobLog service object
+func CreateJobLogService(service *S3DataWatcherService) (*JobLogService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateJobLogService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	dirPath := service.config.GetJobLogDirPath()
+
+	err := os.MkdirAll(dirPath, 0775)
+	if err != nil {
+		err = xerrors.Errorf("failed to make job log dir %s: %w", dirPath, err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	jobLogService := &JobLogService{
+		service:       service,
+		dirPath:       dirPath,
+		retention:     service.config.GetJobLogRetention(),
+		appendLock:    sync.Mutex{},
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+
+		runLogFiles:     map[string]bool{},
+		runLogFilesLock: sync.Mutex{},
+	}
+
+	if jobLogService.retention > 0 {
+		jobLogService.waitGroup.Add(1)
+		go jobLogService.pruneLoop()
+	}
+
+	return jobLogService, nil
+}
+
+// Release releases all resources
+func (jobLogService *JobLogService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "JobLogService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	close(jobLogService.terminateChan)
+	jobLogService.waitGroup.Wait()
+}
+
+// Open starts collecting output of the job run
+func (jobLogService *JobLogService) Open(run *jobRun) (*jobRunLog, error) {
+	jobDirPath := filepath.Join(jobLogService.dirPath, getJobLogDirName(run.job.Name))
+
+	err := os.MkdirAll(jobDirPath, 0775)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to make job log dir %s: %w", jobDirPath, err)
+	}
+
+	file, err := os.CreateTemp(jobDirPath, jobLogTempFilePattern)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create job log temp file in %s: %w", jobDirPath, err)
+	}
+
+	jobLogService.runLogFilesLock.Lock()
+	jobLogService.runLogFiles[file.Name()] = true
+	jobLogService.runLogFilesLock.Unlock()
+
+	return &jobRunLog{
+		jobDirPath:      jobDirPath,
+		file:            file,
+		endsWithNewline: true,
+	}, nil
+}
+
+// Commit appends the output of the finished run to the job's log file of the day the run started
+// the output is delimited by header and footer lines
+func (jobLogService *JobLogService) Commit(run *jobRun, runLog *jobRunLog) error {
+	defer func() {
+		runLog.file.Close()
+		os.Remove(runLog.file.Name())
+
+		jobLogService.runLogFilesLock.Lock()
+		delete(jobLogService.runLogFiles, runLog.file.Name())
+		jobLogService.runLogFilesLock.Unlock()
+	}()
+
+	runLog.terminateLine()
+
+	_, err := runLog.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return xerrors.Errorf("failed to rewind job log temp file %s: %w", runLog.file.Name(), err)
+	}
+
+	logFilePath := filepath.Join(runLog.jobDirPath, run.startTime.Format(jobLogDateLayout)+jobLogFileExt)
+
+	jobLogService.appendLock.Lock()
+	defer jobLogService.appendLock.Unlock()
+
+	logFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return xerrors.Errorf("failed to open job log file %s: %w", logFilePath, err)
+	}
+	defer logFile.Close()
+
+	fmt.Fprintf(logFile, "=== run %s job %q event %s key %s/%s started %s ===\n",
+		run.id,
+		run.job.Name,
+		run.record.EventName,
+		run.record.S3.Bucket.Name,
+		run.record.S3.Object.Key,
+		run.startTime.Format(jobLogTimeLayout),
+	)
+
+	_, err = io.Copy(logFile, runLog.file)
+	if err != nil {
+		return xerrors.Errorf("failed to write job log file %s: %w", logFilePath, err)
+	}
+
+	_, err = fmt.Fprintf(logFile, "=== run %s %s exit %d attempts %d duration %s ===\n",
+		run.id,
+		run.getStatus(),
+		run.exitCode,
+		run.attempts,
+		run.endTime.Sub(run.startTime).Round(time.Millisecond).String(),
+	)
+	if err != nil {
+		return xerrors.Errorf("failed to write job log file %s: %w", logFilePath, err)
+	}
+
+	runLog.filePath = logFilePath
+	return nil
+}
+
+// Write writes job output
+func (runLog *jobRunLog) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		runLog.endsWithNewline = p[len(p)-1] == '\n'
+	}
+	return runLog.file.Write(p)
+}
+
+// terminateLine writes a newline if the output doesn't end with it
+func (runLog *jobRunLog) terminateLine() {
+	if !runLog.endsWithNewline {
+		runLog.file.Write([]byte("\n"))
+		runLog.endsWithNewline = true
+	}
+}
+
+// writeAttemptHeader marks the start of an attempt, used when the job is retried
+func (runLog *jobRunLog) writeAttemptHeader(attempt int, maxAttempts int) {
+	runLog.terminateLine()
+	fmt.Fprintf(runLog.file, "--- attempt %d/%d started %s ---\n", attempt, maxAttempts, time.Now().Format(jobLogTimeLayout))
+}
+
+func (jobLogService *JobLogService) pruneLoop() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "JobLogService",
+		"function": "pruneLoop",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer jobLogService.waitGroup.Done()
+
+	for {
+		pruned, err := jobLogService.prune(time.Now().Add(-jobLogService.retention))
+		if err != nil {
+			logger.WithError(err).Warn("failed to prune job log files")
+		} else if pruned > 0 {
+			logger.Infof("pruned %d job log files", pruned)
+		}
+
+		select {
+		case <-jobLogService.terminateChan:
+			return
+		case <-time.After(jobLogPruneInterval):
+		}
+	}
+}
+
+// prune deletes job log files not modified since the given time
+// this also cleans up temp files left by crashes, but not those of running jobs that are quiet for long
+func (jobLogService *JobLogService) prune(before time.Time) (int, error) {
+	// log files are not removed while output is appended
+	jobLogService.appendLock.Lock()
+	defer jobLogService.appendLock.Unlock()
+
+	jobLogService.runLogFilesLock.Lock()
+	defer jobLogService.runLogFilesLock.Unlock()
+
+	pruned := 0
+	err := filepath.Walk(jobLogService.dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !info.ModTime().Before(before) || jobLogService.runLogFiles[path] {
+			return nil
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		pruned++
+		return nil
+	})
+
+	return pruned, err
+}
+
+// getJobLogDirName returns a dir name safe for the job name
+func getJobLogDirName(jobName string) string {
+	dirName := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, jobName)
+
+	// avoid "." and ".."
+	if strings.Trim(dirName, ".") == "" {
+		return "_" + dirName
+	}
+	return dirName
+}
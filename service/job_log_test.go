@@ -0,0 +1,83 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+)
+
+func TestJobLogPruneSkipsRunningJobs(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.DataRootPath = t.TempDir()
+	config.JobLogRetention = 0 // pruned by the test only
+
+	jobLogService, err := CreateJobLogService(&S3DataWatcherService{
+		config: config,
+	})
+	if err != nil {
+		t.Fatalf("failed to create job log service: %v", err)
+	}
+	defer jobLogService.Release()
+
+	job := &Job{Name: "quiet"}
+
+	// a running job without output for long, and a temp file left by a crash
+	runningRun, err := newRecordJobRun(job, newTestRecord("bucket", "key1", "0001"))
+	if err != nil {
+		t.Fatalf("failed to create run: %v", err)
+	}
+	runningRun.startTime = time.Now()
+
+	runningLog, err := jobLogService.Open(runningRun)
+	if err != nil {
+		t.Fatalf("failed to open job log: %v", err)
+	}
+
+	crashedFilePath := filepath.Join(runningLog.jobDirPath, ".run-crashed")
+	err = os.WriteFile(crashedFilePath, []byte("output\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write crashed temp file: %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{runningLog.file.Name(), crashedFilePath} {
+		err = os.Chtimes(path, old, old)
+		if err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+
+	pruned, err := jobLogService.prune(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+
+	if pruned != 1 {
+		t.Fatalf("expected 1 pruned file, got %d", pruned)
+	}
+
+	if _, err := os.Stat(crashedFilePath); !os.IsNotExist(err) {
+		t.Fatalf("temp file left by crash must be pruned: %v", err)
+	}
+
+	// the running job's output is still committed
+	runningLog.Write([]byte("done\n"))
+	runningRun.endTime = time.Now()
+
+	err = jobLogService.Commit(runningRun, runningLog)
+	if err != nil {
+		t.Fatalf("failed to commit job log: %v", err)
+	}
+
+	content, err := os.ReadFile(runningLog.filePath)
+	if err != nil {
+		t.Fatalf("failed to read job log: %v", err)
+	}
+
+	if len(content) == 0 {
+		t.Fatalf("job log must have the output")
+	}
+}
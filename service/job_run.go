@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/xerrors"
+)
+
+const (
+	runIDLength int = 8 // bytes
+)
+
+// jobRun is an execution of a job for an event, including retries
+type jobRun struct {
+	id      string
+	job     *Job
+	record  events.S3EventRecord // the first record, identifies the run in logs and history
+	records []events.S3EventRecord
+	payload []byte // sent via STDIN
+	// partition of ordered runs, empty if not ordered
+	partition string
+	// spooled events of the records, released when the run finishes
+	spoolEntries []*spoolEntry
+	// result of the job chaining this run, nil if not chained
+	previous   *JobRunResult
+	chainDepth int
+	ctx        context.Context
+	span       trace.Span
+
+	startTime time.Time
+	endTime   time.Time
+	attempts  int
+	exitCode  int
+	err       error
+	output    *tailBuffer
+	stdout    *tailBuffer // parsed as the result of the run
+	result    *JobResult  // reported by the last attempt, nil if not reported
+	outputLog *jobRunLog  // nil if the job doesn't log output
+
+	// status code of the last response of http action
+	statusCode int
+
+	// current attempt, protected by ExternalCmdService.runningJobsLock
+	cmd      *exec.Cmd
+	cancel   context.CancelFunc // cancels the request of http action
+	timedOut int32              // atomic
+}
+
+func newJobRun(job *Job, records []events.S3EventRecord, payload []byte) *jobRun {
+	return &jobRun{
+		id:       newRunID(),
+		job:      job,
+		record:   records[0],
+		records:  records,
+		payload:  payload,
+		ctx:      context.Background(),
+		exitCode: -1,
+		output:   newTailBuffer(commons.JobOutputMaxSize),
+		stdout:   newTailBuffer(commons.JobResultMaxSize),
+	}
+}
+
+// newRecordJobRun creates a run for the record, the record is sent via STDIN in JSON
+func newRecordJobRun(job *Job, record events.S3EventRecord) (*jobRun, error) {
+	recordJson, err := json.Marshal(record)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal record: %w", err)
+	}
+
+	return newJobRun(job, []events.S3EventRecord{record}, recordJson), nil
+}
+
+// addSpoolEntry adds the spooled event to release when the run finishes
+func (run *jobRun) addSpoolEntry(entry *spoolEntry) {
+	if entry != nil {
+		run.spoolEntries = append(run.spoolEntries, entry)
+	}
+}
+
+// getStatus returns the status of the run, valid after the run finishes
+func (run *jobRun) getStatus() string {
+	if run.err == nil {
+		if run.result != nil && run.result.Status == JobResultStatusSkip {
+			return JobRunStatusSkipped
+		}
+		return JobRunStatusSucceeded
+	}
+
+	if run.isTimedOut() {
+		return JobRunStatusTimedOut
+	}
+
+	return JobRunStatusFailed
+}
+
+// isTimedOut returns true if the current attempt timed out
+func (run *jobRun) isTimedOut() bool {
+	return atomic.LoadInt32(&run.timedOut) == 1
+}
+
+// toRecord returns a history record of the run
+func (run *jobRun) toRecord() *JobRunRecord {
+	record := &JobRunRecord{
+		RunID:         run.id,
+		Job:           run.job.Name,
+		EventName:     run.record.EventName,
+		Bucket:        run.record.S3.Bucket.Name,
+		Key:           run.record.S3.Object.Key,
+		ETag:          run.record.S3.Object.ETag,
+		Sequencer:     run.record.S3.Object.Sequencer,
+		StartTime:     run.startTime,
+		EndTime:       run.endTime,
+		Status:        run.getStatus(),
+		ExitCode:      run.exitCode,
+		StatusCode:    run.statusCode,
+		Attempts:      run.attempts,
+		Records:       len(run.records),
+		PreviousRunID: run.getPreviousRunID(),
+		Result:        run.result,
+		Output:        run.output.String(),
+	}
+
+	if run.err != nil {
+		record.Error = run.err.Error()
+	}
+
+	return record
+}
+
+func newRunID() string {
+	idBytes := make([]byte, runIDLength)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		// fallback to time
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(idBytes)
+}
+
+// tailBuffer keeps the last bytes written, used to capture bounded job output
+type tailBuffer struct {
+	lock   sync.Mutex
+	size   int
+	buffer []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{
+		size:   size,
+		buffer: []byte{},
+	}
+}
+
+// Write appends bytes, dropping old bytes over the size
+func (buffer *tailBuffer) Write(p []byte) (int, error) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+
+	buffer.buffer = append(buffer.buffer, p...)
+	if len(buffer.buffer) > buffer.size {
+		buffer.buffer = append([]byte{}, buffer.buffer[len(buffer.buffer)-buffer.size:]...)
+	}
+
+	return len(p), nil
+}
+
+// Reset discards bytes kept
+func (buffer *tailBuffer) Reset() {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+
+	buffer.buffer = []byte{}
+}
+
+// String returns bytes kept
+func (buffer *tailBuffer) String() string {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+
+	return string(buffer.buffer)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"golang.org/x/xerrors"
+)
+
+func newTestRecord(bucket string, key string, sequencer string) events.S3EventRecord {
+	record := events.S3EventRecord{
+		EventName: "s3:ObjectCreated:Put",
+	}
+	record.S3.Bucket.Name = bucket
+	record.S3.Object.Key = key
+	record.S3.Object.Sequencer = sequencer
+	return record
+}
+
+func TestJobRunStatus(t *testing.T) {
+	runErr := xerrors.Errorf("exit status 1")
+
+	tests := []struct {
+		name     string
+		err      error
+		result   string // status of the job result, empty if none
+		timedOut bool
+		status   string
+	}{
+		{"succeeded", nil, "", false, JobRunStatusSucceeded},
+		{"skipped", nil, JobResultStatusSkip, false, JobRunStatusSkipped},
+		{"failed", runErr, "", false, JobRunStatusFailed},
+		{"timed out", runErr, "", true, JobRunStatusTimedOut},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run, err := newRecordJobRun(&Job{Name: "test"}, newTestRecord("bucket", "key", "0001"))
+			if err != nil {
+				t.Fatalf("failed to create run: %v", err)
+			}
+
+			run.err = test.err
+			if len(test.result) > 0 {
+				run.result = &JobResult{Status: test.result}
+			}
+			if test.timedOut {
+				atomic.StoreInt32(&run.timedOut, 1)
+			}
+
+			status := run.getStatus()
+			if status != test.status {
+				t.Fatalf("expected status %s, got %s", test.status, status)
+			}
+		})
+	}
+}
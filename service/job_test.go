@@ -0,0 +1,132 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobsValidateChainCycles(t *testing.T) {
+	newJob := func(name string, onSuccess ...string) Job {
+		return Job{
+			Name:      name,
+			Command:   "/bin/true",
+			OnSuccess: onSuccess,
+		}
+	}
+
+	withOrdering := func(job Job) Job {
+		job.Ordering = &JobOrdering{}
+		return job
+	}
+	withDebounce := func(job Job) Job {
+		job.Debounce = 10
+		return job
+	}
+
+	tests := []struct {
+		name string
+		jobs []Job
+		err  string // empty if valid
+	}{
+		{
+			name: "chain",
+			jobs: []Job{newJob("a", "b", "c"), newJob("b", "c"), newJob("c")},
+		},
+		{
+			name: "self loop",
+			jobs: []Job{newJob("a", "a")},
+			err:  "job chain cycle detected: a -> a",
+		},
+		{
+			name: "indirect cycle",
+			jobs: []Job{newJob("a", "b"), newJob("b", "c"), newJob("c", "b")},
+			err:  "job chain cycle detected: b -> c -> b",
+		},
+		{
+			name: "unknown job",
+			jobs: []Job{newJob("a", "b")},
+			err:  `chains unknown job "b"`,
+		},
+		{
+			name: "ordered job",
+			jobs: []Job{newJob("a", "b"), withOrdering(newJob("b"))},
+			err:  `chains job "b" using dataset trigger, batch, debounce or ordering`,
+		},
+		{
+			name: "debounced job",
+			jobs: []Job{newJob("a", "b"), withDebounce(newJob("b"))},
+			err:  `chains job "b" using dataset trigger, batch, debounce or ordering`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobs := &Jobs{
+				Jobs: test.jobs,
+			}
+
+			err := jobs.Validate()
+			if len(test.err) == 0 {
+				if err != nil {
+					t.Fatalf("expected valid jobs, got %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadJobsJobDir(t *testing.T) {
+	jobDirPath := t.TempDir()
+
+	writeJobFile := func(name string, jobName string) {
+		content := "jobs:\n  - name: " + jobName + "\n    command: /bin/true\n"
+		err := os.WriteFile(filepath.Join(jobDirPath, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to write job file: %v", err)
+		}
+	}
+
+	writeJobFile("a.yaml", "a")
+	writeJobFile("b.yml", "b")
+	writeJobFile("c.yaml.disabled", "c")
+
+	err := os.Mkdir(filepath.Join(jobDirPath, "d.yaml"), 0755)
+	if err != nil {
+		t.Fatalf("failed to make dir: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		jobDirPath string
+		jobs       []string
+	}{
+		{"dir", jobDirPath, []string{"a", "b"}},
+		{"glob", filepath.Join(jobDirPath, "*.yaml"), []string{"a"}},
+		{"glob without matches", filepath.Join(jobDirPath, "*.json"), []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobs, err := LoadJobs("", test.jobDirPath)
+			if err != nil {
+				t.Fatalf("failed to load jobs: %v", err)
+			}
+
+			names := []string{}
+			for _, job := range jobs.Jobs {
+				names = append(names, job.Name)
+			}
+
+			if strings.Join(names, ",") != strings.Join(test.jobs, ",") {
+				t.Fatalf("expected jobs %v, got %v", test.jobs, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// consistent log field names for event-related log lines
+const (
+	logFieldJob       string = "job"
+	logFieldBucket    string = "bucket"
+	logFieldKey       string = "key"
+	logFieldEventName string = "event_name"
+	logFieldSequencer string = "sequencer"
+	logFieldRunID     string = "run_id"
+	logFieldJobLog    string = "job_log"
+)
+
+// getRecordLogFields returns log fields of the event record
+func getRecordLogFields(record events.S3EventRecord) log.Fields {
+	return log.Fields{
+		logFieldBucket:    record.S3.Bucket.Name,
+		logFieldKey:       record.S3.Object.Key,
+		logFieldEventName: record.EventName,
+		logFieldSequencer: record.S3.Object.Sequencer,
+	}
+}
+
+// getRunLogFields returns log fields of the job run
+func getRunLogFields(run *jobRun) log.Fields {
+	fields := getRecordLogFields(run.record)
+	fields[logFieldJob] = run.job.Name
+	fields[logFieldRunID] = run.id
+	return fields
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace string = "s3_data_watcher"
+)
+
+var (
+	metricEventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_received_total",
+		Help:      "The number of events received from Nats",
+	}, []string{"subject", "event_name"})
+
+	metricEventDecodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_decode_failures_total",
+		Help:      "The number of events failed to decode",
+	}, []string{"subject"})
+
+	metricJobsMatched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_matched_total",
+		Help:      "The number of events matched to job filters",
+	}, []string{"job"})
+
+	metricEventsCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_coalesced_total",
+		Help:      "The number of events collapsed into a pending debounced job run",
+	}, []string{"job"})
+
+	metricBatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "batch_records",
+		Help:      "The number of records sent to a batch job run",
+		Buckets:   []float64{1, 5, 10, 50, 100, 500, 1000, 5000},
+	}, []string{"job"})
+
+	metricEventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_dropped_total",
+		Help:      "The number of matched events dropped without running the job",
+	}, []string{"job", "reason"})
+
+	metricEventsForced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_forced_total",
+		Help:      "The number of matched events forced to run the job without duplicate suppression",
+	}, []string{"job"})
+
+	metricCompletionPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "completion_publish_failures_total",
+		Help:      "The number of job completion events failed to publish",
+	}, []string{"job"})
+
+	metricJobsSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_skipped_total",
+		Help:      "The number of job runs reported skip as their result",
+	}, []string{"job"})
+
+	metricJobsChained = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_chained_total",
+		Help:      "The number of job runs started by on_success or on_failure of other jobs",
+	}, []string{"job"})
+
+	metricRunsParked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "runs_parked",
+		Help:      "The number of job runs parked by open circuit breakers",
+	}, []string{"job"})
+
+	metricCircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "circuit_breaker_state",
+		Help:      "The state of the job's circuit breaker, 1 for the current state",
+	}, []string{"job", "state"})
+
+	metricJobsStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_started_total",
+		Help:      "The number of job runs started",
+	}, []string{"job"})
+
+	metricJobsSucceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_succeeded_total",
+		Help:      "The number of job runs succeeded",
+	}, []string{"job"})
+
+	metricJobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_failed_total",
+		Help:      "The number of job runs failed",
+	}, []string{"job"})
+
+	metricJobsTimedOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_timed_out_total",
+		Help:      "The number of job runs killed due to timeout",
+	}, []string{"job"})
+
+	metricJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_duration_seconds",
+		Help:      "The duration of job runs in seconds",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600},
+	}, []string{"job"})
+
+	metricRateLimitDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_delay_seconds",
+		Help:      "The delay of job runs by rate limits in seconds",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600},
+	}, []string{"job", "limit"})
+
+	metricJobsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "jobs_running",
+		Help:      "The number of job runs in progress",
+	})
+
+	metricSpoolEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "spool_entries",
+		Help:      "The number of events in the spool waiting for jobs to finish",
+	})
+
+	metricNatsConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_connected",
+		Help:      "1 if connected to Nats, 0 otherwise",
+	})
+
+	metricNatsReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_reconnects_total",
+		Help:      "The number of reconnections to Nats",
+	})
+)
+
+// newQueueDepthMetric creates a gauge reporting the number of messages waiting to be processed
+func newQueueDepthMetric(function func() float64) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "The number of messages received from Nats waiting to be processed",
+	}, function)
+}
@@ -1,16 +1,20 @@
 package service
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"github.com/cyverse/s3-data-watcher/commons"
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/xerrors"
 )
 
-type S3EventHandler func([]byte)
+type S3EventHandler func(ctx context.Context, subject string, data []byte)
 
 type NatsService struct {
 	service              *S3DataWatcherService
@@ -20,6 +24,9 @@ type NatsService struct {
 	lastConnectTrialTime time.Time
 	connectionLock       sync.Mutex
 	eventHandler         S3EventHandler
+	queueDepthMetric     prometheus.GaugeFunc
+	terminateChan        chan bool
+	waitGroup            sync.WaitGroup
 }
 
 // CreateNatsService creates a Nats service object and connects to Nats
@@ -38,17 +45,55 @@ func CreateNatsService(service *S3DataWatcherService, config *commons.NatsConfig
 		lastConnectTrialTime: time.Time{},
 		connectionLock:       sync.Mutex{},
 		eventHandler:         hander,
+		terminateChan:        make(chan bool),
+		waitGroup:            sync.WaitGroup{},
 	}
 
-	err := natsService.ensureConnected()
+	natsService.queueDepthMetric = newQueueDepthMetric(natsService.getPendingMessages)
+	err := prometheus.Register(natsService.queueDepthMetric)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	err = natsService.ensureConnected()
 	if err != nil {
 		logger.WithError(err).Warn("will retry again")
 		// ignore error
 	}
 
+	natsService.waitGroup.Add(1)
+	go natsService.reconnectLoop()
+
 	return natsService, nil
 }
 
+// reconnectLoop connects to Nats again if the connection is closed
+func (natsService *NatsService) reconnectLoop() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "NatsService",
+		"function": "reconnectLoop",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer natsService.waitGroup.Done()
+
+	for {
+		select {
+		case <-natsService.terminateChan:
+			return
+		case <-time.After(commons.ReconnectInterval):
+		}
+
+		err := natsService.ensureConnected()
+		if err != nil {
+			logger.WithError(err).Warn("will retry again")
+		}
+	}
+}
+
 func (natsService *NatsService) ensureConnected() error {
 	logger := log.WithFields(log.Fields{
 		"package":  "service",
@@ -83,6 +128,26 @@ func (natsService *NatsService) ensureConnected() error {
 	return nil
 }
 
+// IsReady returns ServiceNotReadyError if there is no active subscription to Nats
+// it only reports the connection state, reconnecting is done in background
+func (natsService *NatsService) IsReady() error {
+	// do not wait for connecting
+	if !natsService.connectionLock.TryLock() {
+		return NewServiceNotReadyError("connecting to Nats")
+	}
+	defer natsService.connectionLock.Unlock()
+
+	if natsService.connection == nil || natsService.subscription == nil {
+		return NewServiceNotReadyError("no subscription to Nats")
+	}
+
+	if !natsService.connection.IsConnected() {
+		return NewServiceNotReadyErrorf("connection to Nats is not active - %s", natsService.connection.Status().String())
+	}
+
+	return nil
+}
+
 func (natsService *NatsService) connect() error {
 	logger := log.WithFields(log.Fields{
 		"package":  "service",
@@ -109,6 +174,23 @@ func (natsService *NatsService) connect() error {
 		options = append(options, nats.ReconnectWait(reconnectWait))
 	}
 
+	options = append(options, nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+		metricNatsConnected.Set(0)
+		if err != nil {
+			logger.WithError(err).Warn("disconnected from Nats")
+		}
+	}))
+
+	options = append(options, nats.ReconnectHandler(func(conn *nats.Conn) {
+		metricNatsConnected.Set(1)
+		metricNatsReconnects.Inc()
+		logger.Infof("reconnected to Nats %s", conn.ConnectedUrl())
+	}))
+
+	options = append(options, nats.ClosedHandler(func(conn *nats.Conn) {
+		metricNatsConnected.Set(0)
+	}))
+
 	if len(natsService.config.Subject) == 0 {
 		err := xerrors.Errorf("failed to subscribe an empty subject")
 		logger.Error(err)
@@ -125,8 +207,24 @@ func (natsService *NatsService) connect() error {
 
 	// Add a handler
 	handler := func(msg *nats.Msg) {
+		// continue the trace if the publisher gives traceparent header
+		ctx, span := tracer.Start(extractTraceContext(msg.Header), "nats.receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "nats"),
+				attribute.String("messaging.source", msg.Subject),
+				attribute.Int("messaging.message.payload_size_bytes", len(msg.Data)),
+			),
+		)
+		defer span.End()
+
+		// publishers can force jobs to run for duplicate events
+		if isForceHeaderSet(msg.Header) {
+			ctx = withForceRun(ctx)
+		}
+
 		if natsService.eventHandler != nil {
-			natsService.eventHandler(msg.Data)
+			natsService.eventHandler(ctx, msg.Subject, msg.Data)
 		}
 	}
 
@@ -139,11 +237,29 @@ func (natsService *NatsService) connect() error {
 	}
 
 	natsService.subscription = subscription
+	metricNatsConnected.Set(1)
 	logger.Tracef("established a connection to %s", natsService.config.URL)
 
 	return nil
 }
 
+// getPendingMessages returns the number of messages received but not yet processed
+func (natsService *NatsService) getPendingMessages() float64 {
+	natsService.connectionLock.Lock()
+	defer natsService.connectionLock.Unlock()
+
+	if natsService.subscription == nil {
+		return 0
+	}
+
+	pendingMsgs, _, err := natsService.subscription.Pending()
+	if err != nil {
+		return 0
+	}
+
+	return float64(pendingMsgs)
+}
+
 // Release releases all resources, disconnecting from Nats
 func (natsService *NatsService) Release() {
 	logger := log.WithFields(log.Fields{
@@ -156,6 +272,9 @@ func (natsService *NatsService) Release() {
 
 	logger.Infof("trying to disconnect from %s", natsService.config.URL)
 
+	close(natsService.terminateChan)
+	natsService.waitGroup.Wait()
+
 	natsService.connectionLock.Lock()
 	defer natsService.connectionLock.Unlock()
 
@@ -170,4 +289,11 @@ func (natsService *NatsService) Release() {
 		}
 		natsService.connection = nil
 	}
+
+	metricNatsConnected.Set(0)
+
+	if natsService.queueDepthMetric != nil {
+		prometheus.Unregister(natsService.queueDepthMetric)
+		natsService.queueDepthMetric = nil
+	}
 }
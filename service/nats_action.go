@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/xerrors"
+)
+
+const (
+	natsPublishFlushTimeout time.Duration = 10 * time.Second
+)
+
+// natsExecutor publishes the payload to a Nats subject
+type natsExecutor struct {
+	externalCmdService *ExternalCmdService
+	action             *JobNatsAction
+}
+
+func (executor *natsExecutor) release() {
+}
+
+// execute publishes a message and waits for the server to receive it
+func (executor *natsExecutor) execute(run *jobRun) error {
+	action := executor.action
+
+	subject, err := renderActionTemplate("subject", action.Subject, run)
+	if err != nil {
+		return err
+	}
+
+	err = validatePublishSubject(subject)
+	if err != nil {
+		return err
+	}
+
+	watchedSubject := executor.externalCmdService.service.config.NatsConfig.Subject
+	if matchNatsSubject(watchedSubject, subject) {
+		return xerrors.Errorf("cannot publish to %s, the subject is watched for events", subject)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = run.payload
+	if len(action.Payload) > 0 {
+		payload, err := renderActionTemplate("payload", action.Payload, run)
+		if err != nil {
+			return err
+		}
+		msg.Data = []byte(payload)
+	}
+
+	for key, value := range action.Headers {
+		headerValue, err := renderActionTemplate("header "+key, value, run)
+		if err != nil {
+			return err
+		}
+		msg.Header.Set(key, headerValue)
+	}
+
+	// pass trace context to subscribers
+	otel.GetTextMapPropagator().Inject(run.ctx, natsHeaderCarrier(msg.Header))
+
+	flushTimeout := natsPublishFlushTimeout
+	if run.job.Timeout > 0 {
+		flushTimeout = run.job.GetTimeout()
+	}
+
+	err = executor.externalCmdService.publish(msg, flushTimeout)
+	if err != nil {
+		return err
+	}
+
+	run.exitCode = 0
+	fmt.Fprintf(getRunOutput(run), "published %d bytes to %s\n", len(msg.Data), subject)
+	return nil
+}
+
+// validatePublishSubject returns error if the rendered subject cannot be published to
+func validatePublishSubject(subject string) error {
+	if len(subject) == 0 {
+		return xerrors.Errorf("invalid subject to publish, empty")
+	}
+
+	if strings.IndexFunc(subject, unicode.IsSpace) >= 0 {
+		return xerrors.Errorf("invalid subject to publish %q, must not contain whitespace", subject)
+	}
+
+	for _, token := range strings.Split(subject, ".") {
+		if len(token) == 0 {
+			return xerrors.Errorf("invalid subject to publish %q, must not contain empty tokens", subject)
+		}
+
+		if token == "*" || token == ">" {
+			return xerrors.Errorf("invalid subject to publish %q, must not contain wildcards", subject)
+		}
+	}
+
+	return nil
+}
+
+// matchNatsSubject returns true if the subject matches the pattern having wildcards, * and >
+func matchNatsSubject(pattern string, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for idx, patternToken := range patternTokens {
+		if patternToken == ">" {
+			return len(subjectTokens) > idx
+		}
+
+		if idx >= len(subjectTokens) {
+			return false
+		}
+
+		if patternToken != "*" && patternToken != subjectTokens[idx] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// publish publishes the message with the publisher connection, and waits for the server to receive it
+func (externalCmdService *ExternalCmdService) publish(msg *nats.Msg, flushTimeout time.Duration) error {
+	connection, err := externalCmdService.getPublishConnection()
+	if err != nil {
+		return err
+	}
+
+	err = connection.PublishMsg(msg)
+	if err != nil {
+		return xerrors.Errorf("failed to publish to %s: %w", msg.Subject, err)
+	}
+
+	err = connection.FlushTimeout(flushTimeout)
+	if err != nil {
+		return xerrors.Errorf("failed to flush message published to %s: %w", msg.Subject, err)
+	}
+
+	return nil
+}
+
+// getPublishConnection returns the connection to publish messages from jobs, connects if not connected
+// separate from the subscription, so jobs can publish while spooled events are replayed and running jobs are drained
+func (externalCmdService *ExternalCmdService) getPublishConnection() (*nats.Conn, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "getPublishConnection",
+	})
+
+	externalCmdService.publishConnectionLock.Lock()
+	defer externalCmdService.publishConnectionLock.Unlock()
+
+	if externalCmdService.publishConnection != nil {
+		if !externalCmdService.publishConnection.IsClosed() {
+			return externalCmdService.publishConnection, nil
+		}
+		externalCmdService.publishConnection = nil
+	}
+
+	config := &externalCmdService.service.config.NatsConfig
+
+	options := []nats.Option{}
+
+	if config.MaxReconnects >= 0 {
+		options = append(options, nats.MaxReconnects(config.MaxReconnects))
+	}
+
+	if config.ReconnectWait >= 0 {
+		options = append(options, nats.ReconnectWait(time.Duration(config.ReconnectWait)*time.Second))
+	}
+
+	logger.Infof("connecting to Nats %s to publish", config.URL)
+
+	connection, err := nats.Connect(config.URL, options...)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to connect to Nats %s: %w", config.URL, err)
+	}
+
+	externalCmdService.publishConnection = connection
+	return connection, nil
+}
+
+// closePublishConnection closes the publisher connection, flushing messages published
+func (externalCmdService *ExternalCmdService) closePublishConnection() {
+	externalCmdService.publishConnectionLock.Lock()
+	defer externalCmdService.publishConnectionLock.Unlock()
+
+	if externalCmdService.publishConnection != nil {
+		externalCmdService.publishConnection.Close()
+		externalCmdService.publishConnection = nil
+	}
+}
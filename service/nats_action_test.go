@@ -0,0 +1,31 @@
+package service
+
+import "testing"
+
+func TestValidatePublishSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		valid   bool
+	}{
+		{"jobs.convert.finished", true},
+		{"jobs.data-1.a_b", true},
+		{"", false},
+		{"jobs.my job", false},
+		{"jobs.a\tb", false},
+		{"jobs..finished", false},
+		{".jobs", false},
+		{"jobs.", false},
+		{"jobs.*", false},
+		{"jobs.>", false},
+	}
+
+	for _, test := range tests {
+		err := validatePublishSubject(test.subject)
+		if test.valid && err != nil {
+			t.Errorf("subject %q must be valid: %v", test.subject, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("subject %q must be invalid", test.subject)
+		}
+	}
+}
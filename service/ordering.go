@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventDropReasonOutOfOrder string = "out_of_order"
+)
+
+const (
+	orderingSequencerPruneInterval time.Duration = 1 * time.Minute
+)
+
+// jobPartition serializes runs of a job in a partition
+type jobPartition struct {
+	running bool
+	pending []*pendingRun
+}
+
+// objectSequencer is the latest sequencer accepted for an object
+type objectSequencer struct {
+	sequencer string
+	expires   time.Time
+}
+
+// pendingRun is a run waiting for the running run of the partition to finish
+type pendingRun struct {
+	ctx context.Context
+	run *jobRun
+}
+
+// makePartitionKey makes a key of the job and the partition of the record
+func makePartitionKey(job *Job, record events.S3EventRecord) string {
+	partition := record.S3.Object.Key
+
+	if len(job.Ordering.PartitionPattern) > 0 {
+		pattern := job.Ordering.partitionRegexp
+		if pattern == nil {
+			// not validated
+			pattern, _ = regexp.Compile(job.Ordering.PartitionPattern)
+		}
+
+		if pattern != nil {
+			matches := pattern.FindStringSubmatch(record.S3.Object.Key)
+			if len(matches) >= 2 && len(matches[1]) > 0 {
+				partition = matches[1]
+			}
+		}
+	}
+
+	return job.Name + "\x00" + record.S3.Bucket.Name + "\x00" + partition
+}
+
+// makeSequencerKey makes a key of the job and the object of the record
+func makeSequencerKey(job *Job, record events.S3EventRecord) string {
+	return job.Name + "\x00" + record.S3.Bucket.Name + "\x00" + record.S3.Object.Key
+}
+
+// compareSequencers compares S3 sequencers, hex strings of different lengths
+// returns -1 if a is older than b, 1 if newer, 0 if equal
+func compareSequencers(a string, b string) int {
+	a = strings.ToUpper(a)
+	b = strings.ToUpper(b)
+
+	// left-pad to compare as numbers
+	if len(a) < len(b) {
+		a = strings.Repeat("0", len(b)-len(a)) + a
+	} else if len(b) < len(a) {
+		b = strings.Repeat("0", len(a)-len(b)) + b
+	}
+
+	return strings.Compare(a, b)
+}
+
+// orderJob runs the job after runs of the same partition finish
+// the record is dropped if its sequencer is older than one already accepted for the object
+func (externalCmdService *ExternalCmdService) orderJob(ctx context.Context, job *Job, record events.S3EventRecord) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "orderJob",
+	})
+
+	logger = logger.WithFields(getRecordLogFields(record)).WithField(logFieldJob, job.Name)
+
+	run, err := newRecordJobRun(job, record)
+	if err != nil {
+		logger.Error(err)
+		getSpoolEntry(ctx).release()
+		return
+	}
+
+	run.addSpoolEntry(getSpoolEntry(ctx))
+
+	key := makePartitionKey(job, record)
+	run.partition = key
+
+	sequencer := record.S3.Object.Sequencer
+
+	externalCmdService.partitionsLock.Lock()
+
+	partition, ok := externalCmdService.partitions[key]
+	if !ok {
+		partition = &jobPartition{
+			pending: []*pendingRun{},
+		}
+		externalCmdService.partitions[key] = partition
+	}
+
+	if len(sequencer) > 0 {
+		now := time.Now()
+		externalCmdService.pruneSequencers(now)
+
+		sequencerKey := makeSequencerKey(job, record)
+		last, ok := externalCmdService.sequencers[sequencerKey]
+		if ok && now.Before(last.expires) && compareSequencers(sequencer, last.sequencer) < 0 {
+			lastSequencer := last.sequencer
+			if len(partition.pending) == 0 && !partition.running {
+				delete(externalCmdService.partitions, key)
+			}
+			externalCmdService.partitionsLock.Unlock()
+
+			logger.Infof("drop event older than sequencer %s", lastSequencer)
+			metricEventsDropped.WithLabelValues(job.Name, eventDropReasonOutOfOrder).Inc()
+			releaseSpoolEntries(run.spoolEntries)
+			return
+		}
+
+		externalCmdService.sequencers[sequencerKey] = &objectSequencer{
+			sequencer: sequencer,
+			expires:   now.Add(job.Ordering.GetSequencerTTL()),
+		}
+	}
+
+	if partition.running {
+		partition.pending = append(partition.pending, &pendingRun{
+			ctx: ctx,
+			run: run,
+		})
+		pending := len(partition.pending)
+		externalCmdService.partitionsLock.Unlock()
+
+		logger.Debugf("wait for the running job of the partition (%d pending)", pending)
+		return
+	}
+
+	partition.running = true
+	externalCmdService.evictIdlePartitions()
+	externalCmdService.partitionsLock.Unlock()
+
+	err = externalCmdService.startRun(ctx, run)
+	if err != nil {
+		externalCmdService.releasePartition(key)
+	}
+}
+
+// releasePartition starts the next pending run of the partition
+func (externalCmdService *ExternalCmdService) releasePartition(key string) {
+	for {
+		externalCmdService.partitionsLock.Lock()
+		partition, ok := externalCmdService.partitions[key]
+		if !ok {
+			externalCmdService.partitionsLock.Unlock()
+			return
+		}
+
+		if len(partition.pending) == 0 {
+			partition.running = false
+			externalCmdService.partitionsLock.Unlock()
+			return
+		}
+
+		next := partition.pending[0]
+		partition.pending = partition.pending[1:]
+		externalCmdService.partitionsLock.Unlock()
+
+		err := externalCmdService.startRun(next.ctx, next.run)
+		if err == nil {
+			return
+		}
+
+		// not started, e.g., terminating, try next
+	}
+}
+
+// pruneSequencers forgets expired sequencers, must be called with partitionsLock
+func (externalCmdService *ExternalCmdService) pruneSequencers(now time.Time) {
+	if now.Sub(externalCmdService.sequencersPruneTime) < orderingSequencerPruneInterval {
+		return
+	}
+
+	externalCmdService.sequencersPruneTime = now
+
+	for key, last := range externalCmdService.sequencers {
+		if !now.Before(last.expires) {
+			delete(externalCmdService.sequencers, key)
+		}
+	}
+}
+
+// evictIdlePartitions forgets idle partitions over the limit, must be called with partitionsLock
+// sequencers are kept apart from partitions, so eviction does not accept older events
+func (externalCmdService *ExternalCmdService) evictIdlePartitions() {
+	if len(externalCmdService.partitions) <= commons.OrderingMaxIdle {
+		return
+	}
+
+	for key, partition := range externalCmdService.partitions {
+		if len(externalCmdService.partitions) <= commons.OrderingMaxIdle {
+			return
+		}
+
+		if !partition.running {
+			delete(externalCmdService.partitions, key)
+		}
+	}
+}
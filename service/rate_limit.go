@@ -0,0 +1,98 @@
+package service
+
+import (
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateLimitJob    string = "job"
+	rateLimitBucket string = "bucket"
+)
+
+func newRateLimiter(rateLimit *commons.RateLimitConfig) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(rateLimit.Rate), rateLimit.GetBurst())
+}
+
+func newBucketRateLimiters(rateLimits map[string]commons.RateLimitConfig) map[string]*rate.Limiter {
+	limiters := map[string]*rate.Limiter{}
+	for bucket, rateLimit := range rateLimits {
+		rateLimit := rateLimit
+		limiters[bucket] = newRateLimiter(&rateLimit)
+	}
+	return limiters
+}
+
+// getJobRateLimiter returns the rate limiter of the job, nil if the job has no limit
+// the limiter follows changes of the job file
+func (externalCmdService *ExternalCmdService) getJobRateLimiter(job *Job) *rate.Limiter {
+	externalCmdService.rateLimitersLock.Lock()
+	defer externalCmdService.rateLimitersLock.Unlock()
+
+	if job.RateLimit == nil {
+		delete(externalCmdService.jobRateLimiters, job.Name)
+		return nil
+	}
+
+	limiter, ok := externalCmdService.jobRateLimiters[job.Name]
+	if !ok {
+		limiter = newRateLimiter(job.RateLimit)
+		externalCmdService.jobRateLimiters[job.Name] = limiter
+		return limiter
+	}
+
+	if limiter.Limit() != rate.Limit(job.RateLimit.Rate) {
+		limiter.SetLimit(rate.Limit(job.RateLimit.Rate))
+	}
+
+	if limiter.Burst() != job.RateLimit.GetBurst() {
+		limiter.SetBurst(job.RateLimit.GetBurst())
+	}
+
+	return limiter
+}
+
+// waitRateLimits waits until the job and the bucket of the run are under their rate limits
+// returns false if the service is terminating
+func (externalCmdService *ExternalCmdService) waitRateLimits(run *jobRun) bool {
+	jobLimiter := externalCmdService.getJobRateLimiter(run.job)
+	if jobLimiter != nil && !externalCmdService.waitRateLimiter(run, jobLimiter, rateLimitJob) {
+		return false
+	}
+
+	// limiters of buckets are static, no lock needed
+	bucketLimiter := externalCmdService.bucketRateLimiters[run.record.S3.Bucket.Name]
+	if bucketLimiter != nil && !externalCmdService.waitRateLimiter(run, bucketLimiter, rateLimitBucket) {
+		return false
+	}
+
+	return true
+}
+
+func (externalCmdService *ExternalCmdService) waitRateLimiter(run *jobRun, limiter *rate.Limiter, limit string) bool {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "waitRateLimiter",
+	})
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay <= 0 {
+		return true
+	}
+
+	logger.WithFields(getRunLogFields(run)).Debugf("delay %f seconds by %s rate limit", delay.Seconds(), limit)
+	metricRateLimitDelay.WithLabelValues(run.job.Name, limit).Observe(delay.Seconds())
+
+	select {
+	case <-externalCmdService.terminateChan:
+		reservation.Cancel()
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
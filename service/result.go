@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	"golang.org/x/xerrors"
+)
+
+const (
+	JobResultStatusSuccess string = "success"
+	JobResultStatusSkip    string = "skip"
+	JobResultStatusRetry   string = "retry"
+	JobResultStatusFailure string = "failure"
+
+	// jobs write the result to this fd, or to the last line of stdout
+	resultFD          int    = 3
+	resultFDEnvName   string = "RESULT_FD"
+	resultFilePattern string = "s3-data-watcher-result-*"
+)
+
+// JobResult is a structured result reported by a job as a JSON object, it takes precedence over the exit code
+// success: the run succeeded
+// skip: the run did nothing, jobs are not chained
+// retry: the attempt failed, retry after retry_after seconds if attempts remain
+// failure: the run failed, no more retries
+type JobResult struct {
+	Status string `json:"status"`
+	// seconds, 0 = retry interval of the job
+	RetryAfter float64                `json:"retry_after,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+	Outputs    map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// GetRetryAfter returns the delay before the next attempt
+func (result *JobResult) GetRetryAfter() time.Duration {
+	return time.Duration(result.RetryAfter * float64(time.Second))
+}
+
+// parseJobResult parses the result in the last non-empty line of the output
+// returns nil if the line is not a result, a JSON object with a known status
+func parseJobResult(output []byte) *JobResult {
+	output = bytes.TrimRight(output, " \t\r\n")
+	if idx := bytes.LastIndexByte(output, '\n'); idx >= 0 {
+		output = output[idx+1:]
+	}
+
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 || output[0] != '{' {
+		return nil
+	}
+
+	result := JobResult{}
+	err := json.Unmarshal(output, &result)
+	if err != nil {
+		return nil
+	}
+
+	switch result.Status {
+	case JobResultStatusSuccess, JobResultStatusSkip, JobResultStatusRetry, JobResultStatusFailure:
+		return &result
+	default:
+		return nil
+	}
+}
+
+// applyJobResult reads the result of the attempt and updates the attempt error by the result
+// the result in the result file takes precedence over stdout
+// a result in stdout cannot turn a failed attempt, e.g., non-zero exit or non-2xx response, into success or skip
+func applyJobResult(run *jobRun, err error, resultFile *os.File) error {
+	run.result = nil
+
+	if run.isTimedOut() {
+		// the result may not be complete
+		return err
+	}
+
+	if resultFile != nil {
+		// the job shares the file offset
+		resultFile.Seek(0, io.SeekStart)
+		resultBytes, readErr := io.ReadAll(io.LimitReader(resultFile, int64(commons.JobResultMaxSize)))
+		if readErr == nil {
+			run.result = parseJobResult(resultBytes)
+		}
+	}
+
+	if run.result == nil {
+		run.result = parseJobResult([]byte(run.stdout.String()))
+
+		if run.result != nil && err != nil {
+			switch run.result.Status {
+			case JobResultStatusSuccess, JobResultStatusSkip:
+				// the output may be from a step before the failure
+				run.result = nil
+			}
+		}
+	}
+
+	if run.result == nil {
+		return err
+	}
+
+	switch run.result.Status {
+	case JobResultStatusSuccess, JobResultStatusSkip:
+		return nil
+	default:
+		if err == nil {
+			err = xerrors.Errorf("job reported %s", run.result.Status)
+		}
+
+		if len(run.result.Message) > 0 {
+			err = xerrors.Errorf("%s: %w", run.result.Message, err)
+		}
+		return err
+	}
+}
+
+// createResultFile creates a temp file passed to the job as RESULT_FD
+func createResultFile() (*os.File, error) {
+	file, err := os.CreateTemp("", resultFilePattern)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create result file: %w", err)
+	}
+
+	// only the job and this process hold the file
+	os.Remove(file.Name())
+	return file, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestParseJobResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		status string // empty if not a result
+	}{
+		{"success", `{"status": "success"}`, JobResultStatusSuccess},
+		{"last line", "working\n" + `{"status": "retry", "retry_after": 30}` + "\n\n", JobResultStatusRetry},
+		{"crlf", `{"status": "skip"}` + "\r\n", JobResultStatusSkip},
+		{"not last line", `{"status": "failure"}` + "\ndone\n", ""},
+		{"unknown status", `{"status": "ok"}`, ""},
+		{"no status", `{"outputs": {"path": "a"}}`, ""},
+		{"not an object", `["success"]`, ""},
+		{"broken json", `{"status": "success"`, ""},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := parseJobResult([]byte(test.output))
+			if len(test.status) == 0 {
+				if result != nil {
+					t.Fatalf("expected no result, got %+v", result)
+				}
+				return
+			}
+
+			if result == nil || result.Status != test.status {
+				t.Fatalf("expected %s result, got %+v", test.status, result)
+			}
+		})
+	}
+
+	result := parseJobResult([]byte(`{"status": "retry", "retry_after": 1.5, "message": "busy", "outputs": {"path": "a"}}`))
+	if result == nil || result.GetRetryAfter().Seconds() != 1.5 || result.Message != "busy" || result.Outputs["path"] != "a" {
+		t.Fatalf("unexpected result fields %+v", result)
+	}
+}
+
+func TestApplyJobResult(t *testing.T) {
+	exitErr := xerrors.Errorf("exit status 1")
+
+	tests := []struct {
+		name       string
+		attemptErr error
+		stdout     string
+		resultFile string
+		failed     bool
+		status     string // of the result kept, empty if none
+	}{
+		{"no result", nil, "done\n", "", false, ""},
+		{"no result failed", exitErr, "done\n", "", true, ""},
+		{"stdout success", nil, `{"status": "success"}`, "", false, JobResultStatusSuccess},
+		{"stdout retry", nil, `{"status": "retry"}`, "", true, JobResultStatusRetry},
+		{"stdout success ignored on failure", exitErr, `{"status": "success"}`, "", true, ""},
+		{"stdout skip ignored on failure", exitErr, `{"status": "skip"}`, "", true, ""},
+		{"stdout failure on failure", exitErr, `{"status": "failure"}`, "", true, JobResultStatusFailure},
+		{"result file success on failure", exitErr, "", `{"status": "success"}`, false, JobResultStatusSuccess},
+		{"result file over stdout", nil, `{"status": "success"}`, `{"status": "failure"}`, true, JobResultStatusFailure},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run, err := newRecordJobRun(&Job{Name: "test"}, newTestRecord("bucket", "key", "0001"))
+			if err != nil {
+				t.Fatalf("failed to create run: %v", err)
+			}
+
+			run.stdout.Write([]byte(test.stdout))
+
+			resultFile, err := createResultFile()
+			if err != nil {
+				t.Fatalf("failed to create result file: %v", err)
+			}
+			defer resultFile.Close()
+
+			resultFile.WriteString(test.resultFile)
+
+			err = applyJobResult(run, test.attemptErr, resultFile)
+			if test.failed != (err != nil) {
+				t.Fatalf("expected failed %t, got %v", test.failed, err)
+			}
+
+			status := ""
+			if run.result != nil {
+				status = run.result.Status
+			}
+
+			if status != test.status {
+				t.Fatalf("expected result %q, got %q", test.status, status)
+			}
+		})
+	}
+}
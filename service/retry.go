@@ -0,0 +1,94 @@
+package service
+
+import (
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+// GetMaxAttempts returns max attempts of the job
+func (job *Job) GetMaxAttempts() int {
+	if job.MaxAttempts < 1 {
+		return 1
+	}
+	return job.MaxAttempts
+}
+
+// GetRetryInterval returns interval between attempts
+func (job *Job) GetRetryInterval() time.Duration {
+	return time.Duration(job.RetryInterval) * time.Second
+}
+
+func (job *Job) validateRetry() error {
+	if job.MaxAttempts < 0 || job.RetryInterval < 0 {
+		return xerrors.Errorf("job %q max attempts and retry interval must not be negative", job.Name)
+	}
+	return nil
+}
+
+// isRetryable returns true if the failed attempt can be retried, jobs can report failure not to retry
+func (run *jobRun) isRetryable() bool {
+	return run.result == nil || run.result.Status == JobResultStatusRetry
+}
+
+// getRetryInterval returns the delay before the next attempt, jobs can report retry after
+func (run *jobRun) getRetryInterval() time.Duration {
+	if run.result != nil && run.result.Status == JobResultStatusRetry && run.result.RetryAfter > 0 {
+		return run.result.GetRetryAfter()
+	}
+	return run.job.GetRetryInterval()
+}
+
+// executeAttempts runs attempts of the run until one succeeds, the job reports failure not to retry,
+// or the run reaches max attempts of the job
+func (externalCmdService *ExternalCmdService) executeAttempts(run *jobRun) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "ExternalCmdService",
+		"function": "executeAttempts",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	logger = logger.WithFields(getRunLogFields(run))
+
+	maxAttempts := run.job.GetMaxAttempts()
+
+	for {
+		// retries are also rate limited
+		if run.attempts > 0 && !externalCmdService.waitRateLimits(run) {
+			run.err = xerrors.Errorf("service is terminating, stop waiting for rate limits")
+			logger.Warn(run.err)
+			return
+		}
+
+		run.attempts++
+		if run.outputLog != nil && maxAttempts > 1 {
+			run.outputLog.writeAttemptHeader(run.attempts, maxAttempts)
+		}
+		run.err = externalCmdService.executeAttempt(run)
+		if run.err == nil || run.attempts >= maxAttempts || !run.isRetryable() {
+			return
+		}
+
+		retryInterval := run.getRetryInterval()
+		logger.WithError(run.err).Warnf("job attempt %d/%d failed, retry in %f seconds", run.attempts, maxAttempts, retryInterval.Seconds())
+
+		if !externalCmdService.waitRetry(retryInterval) {
+			logger.Warn("service is terminating, stop retrying")
+			return
+		}
+	}
+}
+
+// waitRetry waits for the retry interval, returns false if the service is terminating
+func (externalCmdService *ExternalCmdService) waitRetry(interval time.Duration) bool {
+	select {
+	case <-externalCmdService.terminateChan:
+		return false
+	case <-time.After(interval):
+		return true
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+)
+
+func TestExecuteAttempts(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.DataRootPath = t.TempDir()
+	config.JobFilePath = filepath.Join(config.DataRootPath, "jobs.yaml")
+
+	externalCmdService, err := CreateExternalCmdService(&S3DataWatcherService{
+		config: config,
+	})
+	if err != nil {
+		t.Fatalf("failed to create external cmd service: %v", err)
+	}
+	t.Cleanup(externalCmdService.Release)
+
+	tests := []struct {
+		name        string
+		script      string
+		maxAttempts int
+		attempts    int
+		failed      bool
+	}{
+		{"no retry", "exit 1", 0, 1, true},
+		{"max attempts", "exit 1", 3, 3, true},
+		{"succeeds on retry", `[ -e "$0.done" ] && exit 0; touch "$0.done"; exit 1`, 3, 2, false},
+		{"failure result not retried", `echo '{"status": "failure"}'; exit 1`, 3, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scriptPath := filepath.Join(t.TempDir(), "job.sh")
+			err := os.WriteFile(scriptPath, []byte("#!/bin/sh\n"+test.script+"\n"), 0755)
+			if err != nil {
+				t.Fatalf("failed to write job script: %v", err)
+			}
+
+			job := &Job{
+				Name:        "test",
+				Command:     scriptPath,
+				MaxAttempts: test.maxAttempts,
+			}
+
+			run, err := newRecordJobRun(job, newTestRecord("bucket", "key", "0001"))
+			if err != nil {
+				t.Fatalf("failed to create run: %v", err)
+			}
+			run.ctx = context.Background()
+
+			externalCmdService.executeAttempts(run)
+
+			if run.attempts != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, run.attempts)
+			}
+
+			if test.failed != (run.err != nil) {
+				t.Fatalf("expected failed %t, got %v", test.failed, run.err)
+			}
+		})
+	}
+}
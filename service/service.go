@@ -1,6 +1,8 @@
 package service
 
 import (
+	"sync"
+
 	"github.com/cyverse/s3-data-watcher/commons"
 	log "github.com/sirupsen/logrus"
 )
@@ -11,6 +13,17 @@ type S3DataWatcherService struct {
 
 	externalCmdService *ExternalCmdService
 	natsService        *NatsService
+	systemdService     *SystemdService
+	httpService        *HTTPService
+	adminHTTPService   *HTTPService
+	tracingService     *TracingService
+	historyService     *HistoryService
+	jobLogService      *JobLogService
+	dedupService       *DedupService
+	spoolService       *SpoolService
+
+	// guards natsService and externalCmdService read by health checks while releasing
+	servicesLock sync.RWMutex
 }
 
 // NewService creates a new Service
@@ -26,25 +39,165 @@ func NewService(config *commons.Config) (*S3DataWatcherService, error) {
 		config: config,
 	}
 
+	tracingService, err := CreateTracingService(service, &config.TracingConfig)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	service.tracingService = tracingService
+
+	historyService, err := CreateHistoryService(service)
+	if err != nil {
+		logger.Error(err)
+		service.Release()
+		return nil, err
+	}
+
+	service.historyService = historyService
+
+	if config.DedupTTL > 0 {
+		dedupService, err := CreateDedupService(service)
+		if err != nil {
+			logger.Error(err)
+			service.Release()
+			return nil, err
+		}
+
+		service.dedupService = dedupService
+	}
+
+	jobLogService, err := CreateJobLogService(service)
+	if err != nil {
+		logger.Error(err)
+		service.Release()
+		return nil, err
+	}
+
+	service.jobLogService = jobLogService
+
+	spoolService, err := CreateSpoolService(service)
+	if err != nil {
+		logger.Error(err)
+		service.Release()
+		return nil, err
+	}
+
+	service.spoolService = spoolService
+
 	externalCmdService, err := CreateExternalCmdService(service)
 	if err != nil {
 		logger.Error(err)
+		service.Release()
 		return nil, err
 	}
 
+	service.servicesLock.Lock()
 	service.externalCmdService = externalCmdService
+	service.servicesLock.Unlock()
+
+	// process events left by the previous run before receiving new events
+	err = externalCmdService.ReplaySpool()
+	if err != nil {
+		logger.WithError(err).Error("failed to replay spooled events")
+	}
 
 	natsService, err := CreateNatsService(service, &config.NatsConfig, externalCmdService.S3EventHandler)
 	if err != nil {
 		logger.Error(err)
+		service.Release()
 		return nil, err
 	}
 
+	service.servicesLock.Lock()
 	service.natsService = natsService
+	service.servicesLock.Unlock()
+
+	if len(config.HTTPListenAddress) > 0 {
+		httpService, err := CreateHTTPService(service, config.HTTPListenAddress)
+		if err != nil {
+			logger.Error(err)
+			service.Release()
+			return nil, err
+		}
+
+		service.httpService = httpService
+	}
+
+	if len(config.AdminListenAddress) > 0 {
+		adminHTTPService, err := CreateAdminHTTPService(service, config.AdminListenAddress, config.AdminToken)
+		if err != nil {
+			logger.Error(err)
+			service.Release()
+			return nil, err
+		}
+
+		service.adminHTTPService = adminHTTPService
+	}
+
+	systemdService, err := CreateSystemdService(service)
+	if err != nil {
+		logger.Error(err)
+		service.Release()
+		return nil, err
+	}
+
+	service.systemdService = systemdService
 
 	return service, nil
 }
 
+// getNatsService returns the Nats service, nil if not created or released
+func (svc *S3DataWatcherService) getNatsService() *NatsService {
+	svc.servicesLock.RLock()
+	defer svc.servicesLock.RUnlock()
+
+	return svc.natsService
+}
+
+// getExternalCmdService returns the external command service, nil if not created or released
+func (svc *S3DataWatcherService) getExternalCmdService() *ExternalCmdService {
+	svc.servicesLock.RLock()
+	defer svc.servicesLock.RUnlock()
+
+	return svc.externalCmdService
+}
+
+// IsConnected returns error if the service is not subscribed to Nats
+func (svc *S3DataWatcherService) IsConnected() error {
+	natsService := svc.getNatsService()
+	if natsService == nil {
+		return NewServiceNotReadyError("Nats service is not created")
+	}
+
+	return natsService.IsReady()
+}
+
+// IsReady returns ServiceNotReadyError if the service is not ready to process events
+func (svc *S3DataWatcherService) IsReady() error {
+	err := svc.IsConnected()
+	if err != nil {
+		return err
+	}
+
+	externalCmdService := svc.getExternalCmdService()
+	if externalCmdService == nil {
+		return NewServiceNotReadyError("external command service is not created")
+	}
+
+	return externalCmdService.IsReady()
+}
+
+// IsAlive returns error if the service is stuck and needs to be restarted
+func (svc *S3DataWatcherService) IsAlive() error {
+	externalCmdService := svc.getExternalCmdService()
+	if externalCmdService == nil {
+		return nil
+	}
+
+	return externalCmdService.IsAlive()
+}
+
 // Release releases the service
 func (svc *S3DataWatcherService) Release() {
 	logger := log.WithFields(log.Fields{
@@ -57,13 +210,63 @@ func (svc *S3DataWatcherService) Release() {
 
 	defer commons.StackTraceFromPanic(logger)
 
-	if svc.natsService != nil {
-		svc.natsService.Release()
-		svc.natsService = nil
+	if svc.systemdService != nil {
+		svc.systemdService.Release()
+		svc.systemdService = nil
+	}
+
+	if svc.httpService != nil {
+		svc.httpService.Release()
+		svc.httpService = nil
+	}
+
+	if svc.adminHTTPService != nil {
+		svc.adminHTTPService.Release()
+		svc.adminHTTPService = nil
+	}
+
+	svc.servicesLock.Lock()
+	natsService := svc.natsService
+	svc.natsService = nil
+	svc.servicesLock.Unlock()
+
+	if natsService != nil {
+		natsService.Release()
+	}
+
+	svc.servicesLock.Lock()
+	externalCmdService := svc.externalCmdService
+	svc.externalCmdService = nil
+	svc.servicesLock.Unlock()
+
+	if externalCmdService != nil {
+		externalCmdService.Release()
+	}
+
+	if svc.spoolService != nil {
+		svc.spoolService.Release()
+		svc.spoolService = nil
+	}
+
+	if svc.jobLogService != nil {
+		svc.jobLogService.Release()
+		svc.jobLogService = nil
+	}
+
+	if svc.dedupService != nil {
+		svc.dedupService.Release()
+		svc.dedupService = nil
+	}
+
+	// record history of jobs drained
+	if svc.historyService != nil {
+		svc.historyService.Release()
+		svc.historyService = nil
 	}
 
-	if svc.externalCmdService != nil {
-		svc.externalCmdService.Release()
-		svc.externalCmdService = nil
+	// flush spans of jobs
+	if svc.tracingService != nil {
+		svc.tracingService.Release()
+		svc.tracingService = nil
 	}
 }
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/xerrors"
+)
+
+const (
+	spoolFileExt     string = ".json"
+	spoolTempFileExt string = ".tmp"
+	spoolDoneFileExt string = ".done" // job paths finished for the event, one per line
+)
+
+// spooledEvent is an event message written to the spool
+type spooledEvent struct {
+	Subject    string    `json:"subject"`
+	Data       []byte    `json:"data"`
+	Force      bool      `json:"force,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// makeSpoolJobName makes a name of the job path for the record of the spooled event
+func makeSpoolJobName(job *Job, recordIdx int) string {
+	return fmt.Sprintf("%d/%s", recordIdx, job.Name)
+}
+
+// spoolFile is an event in the spool, removed when all references are released
+type spoolFile struct {
+	filePath   string
+	references int32
+
+	jobReferences     map[string]int  // key: job path name
+	finishedJobs      map[string]bool // key: job path name, finished before the restart
+	jobReferencesLock sync.Mutex
+}
+
+// getDoneFilePath returns the path of the file recording jobs finished for the event
+func (file *spoolFile) getDoneFilePath() string {
+	return file.filePath + spoolDoneFileExt
+}
+
+// markJobFinished records the job finished for the event, so it is not replayed
+func (file *spoolFile) markJobFinished(jobName string) error {
+	doneFile, err := os.OpenFile(file.getDoneFilePath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = doneFile.WriteString(jobName + "\n")
+	if err != nil {
+		doneFile.Close()
+		return err
+	}
+
+	err = doneFile.Sync()
+	if err != nil {
+		doneFile.Close()
+		return err
+	}
+
+	return doneFile.Close()
+}
+
+// spoolEntry is a reference to an event in the spool
+// the event handler holds a reference while dispatching, and each job path holds one until its run finishes
+// references of a job path carry the job name, the job is finished for the event when all of them are released
+type spoolEntry struct {
+	file *spoolFile
+	job  string
+}
+
+// newSpoolEntry returns the first reference to the spooled event
+func newSpoolEntry(filePath string, finishedJobs map[string]bool) *spoolEntry {
+	return &spoolEntry{
+		file: &spoolFile{
+			filePath:      filePath,
+			references:    1,
+			jobReferences: map[string]int{},
+			finishedJobs:  finishedJobs,
+		},
+	}
+}
+
+// acquire adds a reference
+func (entry *spoolEntry) acquire() {
+	if entry == nil {
+		return
+	}
+
+	atomic.AddInt32(&entry.file.references, 1)
+
+	if len(entry.job) > 0 {
+		entry.file.jobReferencesLock.Lock()
+		entry.file.jobReferences[entry.job]++
+		entry.file.jobReferencesLock.Unlock()
+	}
+}
+
+// acquireJob returns a new reference held by the job path
+func (entry *spoolEntry) acquireJob(jobName string) *spoolEntry {
+	if entry == nil {
+		return nil
+	}
+
+	jobEntry := &spoolEntry{
+		file: entry.file,
+		job:  jobName,
+	}
+	jobEntry.acquire()
+	return jobEntry
+}
+
+// isJobFinished returns true if the job finished for the event before the restart
+func (entry *spoolEntry) isJobFinished(jobName string) bool {
+	if entry == nil {
+		return false
+	}
+
+	return entry.file.finishedJobs[jobName]
+}
+
+// release removes a reference, the event is removed from the spool when no reference left
+func (entry *spoolEntry) release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "spoolEntry",
+		"function": "release",
+	})
+
+	if entry == nil {
+		return
+	}
+
+	if len(entry.job) > 0 {
+		entry.file.jobReferencesLock.Lock()
+		entry.file.jobReferences[entry.job]--
+		jobFinished := entry.file.jobReferences[entry.job] <= 0
+		if jobFinished {
+			delete(entry.file.jobReferences, entry.job)
+		}
+		entry.file.jobReferencesLock.Unlock()
+
+		// not needed if the event is removed right after
+		if jobFinished && atomic.LoadInt32(&entry.file.references) > 1 {
+			err := entry.file.markJobFinished(entry.job)
+			if err != nil {
+				// the job runs again on replay
+				logger.WithError(err).Warnf("failed to record job %q finished for spooled event %s", entry.job, entry.file.filePath)
+			}
+		}
+	}
+
+	if atomic.AddInt32(&entry.file.references, -1) != 0 {
+		return
+	}
+
+	metricSpoolEntries.Dec()
+
+	err := os.Remove(entry.file.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		logger.WithError(err).Warnf("failed to remove spooled event %s", entry.file.filePath)
+	}
+
+	err = os.Remove(entry.file.getDoneFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		logger.WithError(err).Warnf("failed to remove spooled event %s", entry.file.getDoneFilePath())
+	}
+}
+
+type spoolEntryContextKey struct{}
+
+// withSpoolEntry returns a context carrying the spool entry of the event
+func withSpoolEntry(ctx context.Context, entry *spoolEntry) context.Context {
+	return context.WithValue(ctx, spoolEntryContextKey{}, entry)
+}
+
+// getSpoolEntry returns the spool entry of the event, nil if not spooled
+func getSpoolEntry(ctx context.Context) *spoolEntry {
+	entry, _ := ctx.Value(spoolEntryContextKey{}).(*spoolEntry)
+	return entry
+}
+
+// releaseSpoolEntries releases all entries
+func releaseSpoolEntries(entries []*spoolEntry) {
+	for _, entry := range entries {
+		entry.release()
+	}
+}
+
+// SpoolService writes received events to disk before processing, so events are not lost on crash
+type SpoolService struct {
+	service *S3DataWatcherService
+	dirPath string
+}
+
+// CreateSpoolService creates a Spool service object
+func CreateSpoolService(service *S3DataWatcherService) (*SpoolService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateSpoolService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	dirPath := service.config.GetSpoolDirPath()
+
+	err := os.MkdirAll(dirPath, 0775)
+	if err != nil {
+		err = xerrors.Errorf("failed to make spool dir %s: %w", dirPath, err)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &SpoolService{
+		service: service,
+		dirPath: dirPath,
+	}, nil
+}
+
+// Release releases all resources
+func (spoolService *SpoolService) Release() {
+}
+
+// Write writes the event durably, returns the spool entry having a reference for the caller
+func (spoolService *SpoolService) Write(subject string, data []byte, force bool) (*spoolEntry, error) {
+	eventBytes, err := json.Marshal(spooledEvent{
+		Subject:    subject,
+		Data:       data,
+		Force:      force,
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal event: %w", err)
+	}
+
+	// sortable by receipt time to replay in order
+	fileName := fmt.Sprintf("%020d-%s", time.Now().UnixNano(), newRunID())
+	filePath := filepath.Join(spoolService.dirPath, fileName+spoolFileExt)
+	tempFilePath := filepath.Join(spoolService.dirPath, fileName+spoolTempFileExt)
+
+	err = writeFileSync(tempFilePath, eventBytes)
+	if err != nil {
+		os.Remove(tempFilePath)
+		return nil, xerrors.Errorf("failed to write spool file %s: %w", tempFilePath, err)
+	}
+
+	err = os.Rename(tempFilePath, filePath)
+	if err != nil {
+		os.Remove(tempFilePath)
+		return nil, xerrors.Errorf("failed to rename spool file %s: %w", tempFilePath, err)
+	}
+
+	err = syncDir(spoolService.dirPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to sync spool dir %s: %w", spoolService.dirPath, err)
+	}
+
+	metricSpoolEntries.Inc()
+
+	return newSpoolEntry(filePath, map[string]bool{}), nil
+}
+
+// Replay calls the handler for events left in the spool, oldest first
+// the handler gets a context carrying the spool entry, which knows the jobs already finished for the event
+func (spoolService *SpoolService) Replay(handler func(ctx context.Context, subject string, data []byte, force bool)) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "SpoolService",
+		"function": "Replay",
+	})
+
+	dirEntries, err := os.ReadDir(spoolService.dirPath)
+	if err != nil {
+		return xerrors.Errorf("failed to read spool dir %s: %w", spoolService.dirPath, err)
+	}
+
+	filePaths := []string{}
+	doneFilePaths := map[string]bool{}
+	for _, dirEntry := range dirEntries {
+		filePath := filepath.Join(spoolService.dirPath, dirEntry.Name())
+
+		if strings.HasSuffix(dirEntry.Name(), spoolTempFileExt) {
+			// not completely written, the event was not processed
+			os.Remove(filePath)
+			continue
+		}
+
+		if strings.HasSuffix(dirEntry.Name(), spoolFileExt) {
+			filePaths = append(filePaths, filePath)
+			continue
+		}
+
+		if strings.HasSuffix(dirEntry.Name(), spoolDoneFileExt) {
+			doneFilePaths[filePath] = true
+		}
+	}
+
+	// remove records of events already removed
+	for doneFilePath := range doneFilePaths {
+		if _, err := os.Stat(strings.TrimSuffix(doneFilePath, spoolDoneFileExt)); os.IsNotExist(err) {
+			os.Remove(doneFilePath)
+		}
+	}
+
+	sort.Strings(filePaths)
+
+	if len(filePaths) > 0 {
+		logger.Infof("replaying %d spooled events", len(filePaths))
+	}
+
+	for _, filePath := range filePaths {
+		eventBytes, err := os.ReadFile(filePath)
+		if err != nil {
+			logger.WithError(err).Errorf("failed to read spooled event %s", filePath)
+			continue
+		}
+
+		event := spooledEvent{}
+		err = json.Unmarshal(eventBytes, &event)
+		if err != nil {
+			logger.WithError(err).Errorf("failed to unmarshal spooled event %s, remove", filePath)
+			os.Remove(filePath)
+			continue
+		}
+
+		finishedJobs, err := readFinishedJobs(filePath + spoolDoneFileExt)
+		if err != nil {
+			logger.WithError(err).Warnf("failed to read jobs finished for spooled event %s, replay all jobs", filePath)
+		}
+
+		metricSpoolEntries.Inc()
+
+		entry := newSpoolEntry(filePath, finishedJobs)
+
+		handler(withSpoolEntry(context.Background(), entry), event.Subject, event.Data, event.Force)
+	}
+
+	return nil
+}
+
+// readFinishedJobs reads job names recorded finished for the spooled event
+func readFinishedJobs(doneFilePath string) (map[string]bool, error) {
+	finishedJobs := map[string]bool{}
+
+	doneBytes, err := os.ReadFile(doneFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return finishedJobs, nil
+		}
+		return finishedJobs, err
+	}
+
+	for _, line := range strings.Split(string(doneBytes), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 {
+			finishedJobs[line] = true
+		}
+	}
+
+	return finishedJobs, nil
+}
+
+// writeFileSync writes data to the file and flushes it to disk
+func writeFileSync(filePath string, data []byte) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// syncDir flushes dir entries to disk
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
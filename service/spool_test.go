@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+)
+
+func TestSpoolReplaysUnfinishedJobs(t *testing.T) {
+	config := commons.NewDefaultConfig()
+	config.DataRootPath = t.TempDir()
+
+	spoolService, err := CreateSpoolService(&S3DataWatcherService{
+		config: config,
+	})
+	if err != nil {
+		t.Fatalf("failed to create spool service: %v", err)
+	}
+
+	jobA := makeSpoolJobName(&Job{Name: "a"}, 0)
+	jobB := makeSpoolJobName(&Job{Name: "b"}, 0)
+
+	entry, err := spoolService.Write("events", []byte("{}"), true)
+	if err != nil {
+		t.Fatalf("failed to write event: %v", err)
+	}
+
+	// job a finishes, job b is still running at the crash
+	entryA := entry.acquireJob(jobA)
+	entryB := entry.acquireJob(jobB)
+	entry.release()
+	entryA.release()
+	_ = entryB
+
+	replayed := 0
+	err = spoolService.Replay(func(ctx context.Context, subject string, data []byte, force bool) {
+		replayed++
+
+		replayedEntry := getSpoolEntry(ctx)
+		if !replayedEntry.isJobFinished(jobA) {
+			t.Errorf("job a must be finished")
+		}
+		if replayedEntry.isJobFinished(jobB) {
+			t.Errorf("job b must not be finished")
+		}
+		if !force {
+			t.Errorf("force flag must be kept")
+		}
+
+		replayedEntry.release()
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+
+	if replayed != 1 {
+		t.Fatalf("expected 1 replayed event, got %d", replayed)
+	}
+
+	// the event and its record of finished jobs are removed
+	replayed = 0
+	err = spoolService.Replay(func(ctx context.Context, subject string, data []byte, force bool) {
+		replayed++
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+
+	if replayed != 0 {
+		t.Fatalf("expected no replayed event, got %d", replayed)
+	}
+}
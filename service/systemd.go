@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/cyverse/s3-data-watcher/commons"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	systemdNotReadyCheckInterval time.Duration = 1 * time.Second
+)
+
+// SystemdService notifies readiness, status and watchdog to systemd
+type SystemdService struct {
+	service          *S3DataWatcherService
+	isConnected      func() error
+	isReady          func() error
+	isAlive          func() error
+	watchdogInterval time.Duration
+	notifiedReady    bool
+	terminateChan    chan bool
+	waitGroup        sync.WaitGroup
+}
+
+// CreateSystemdService creates a Systemd service object
+// it does nothing if not running under systemd with Type=notify
+func CreateSystemdService(service *S3DataWatcherService) (*SystemdService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateSystemdService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	systemdService := &SystemdService{
+		service:          service,
+		isConnected:      service.IsConnected,
+		isReady:          service.IsReady,
+		isAlive:          service.IsAlive,
+		watchdogInterval: 0,
+		notifiedReady:    false,
+		terminateChan:    make(chan bool),
+		waitGroup:        sync.WaitGroup{},
+	}
+
+	if len(os.Getenv("NOTIFY_SOCKET")) == 0 {
+		logger.Debug("NOTIFY_SOCKET is not set, disable systemd notification")
+		return systemdService, nil
+	}
+
+	watchdogTimeout, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logger.WithError(err).Warn("failed to check systemd watchdog, disable watchdog")
+	} else if watchdogTimeout > 0 {
+		// ping twice in the timeout
+		systemdService.watchdogInterval = watchdogTimeout / 2
+		logger.Infof("systemd watchdog is enabled, ping every %f seconds", systemdService.watchdogInterval.Seconds())
+	}
+
+	systemdService.waitGroup.Add(1)
+	go systemdService.notifyLoop()
+
+	return systemdService, nil
+}
+
+// Release releases all resources, notifying stopping to systemd
+func (systemdService *SystemdService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "SystemdService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	if len(os.Getenv("NOTIFY_SOCKET")) == 0 {
+		return
+	}
+
+	close(systemdService.terminateChan)
+	systemdService.waitGroup.Wait()
+
+	systemdService.notify(daemon.SdNotifyStopping)
+}
+
+func (systemdService *SystemdService) notifyLoop() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "SystemdService",
+		"function": "notifyLoop",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	defer systemdService.waitGroup.Done()
+
+	for {
+		systemdService.update()
+
+		select {
+		case <-systemdService.terminateChan:
+			return
+		case <-time.After(systemdService.getUpdateInterval()):
+		}
+	}
+}
+
+func (systemdService *SystemdService) getUpdateInterval() time.Duration {
+	if !systemdService.notifiedReady {
+		return systemdNotReadyCheckInterval
+	}
+
+	if systemdService.watchdogInterval > 0 && systemdService.watchdogInterval < commons.SystemdStatusUpdateInterval {
+		return systemdService.watchdogInterval
+	}
+
+	return commons.SystemdStatusUpdateInterval
+}
+
+func (systemdService *SystemdService) update() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "SystemdService",
+		"function": "update",
+	})
+
+	readyErr := systemdService.isReady()
+	if readyErr != nil {
+		logger.Debugf("service is not ready - %v", readyErr)
+	}
+
+	if readyErr == nil && !systemdService.notifiedReady {
+		logger.Info("notifying ready to systemd")
+		systemdService.notify(daemon.SdNotifyReady)
+		systemdService.notifiedReady = true
+	}
+
+	runningJobs := 0
+	processedEvents := uint64(0)
+	externalCmdService := systemdService.service.getExternalCmdService()
+	if externalCmdService != nil {
+		runningJobs = externalCmdService.GetRunningJobs()
+		processedEvents = externalCmdService.GetProcessedEvents()
+	}
+
+	// the job file may fail to load while connected
+	connected := systemdService.isConnected() == nil
+	status := fmt.Sprintf("STATUS=connected=%t, jobs running=%d, events processed=%d", connected, runningJobs, processedEvents)
+	if readyErr != nil {
+		status += fmt.Sprintf(", not ready: %s", readyErr.Error())
+	}
+	systemdService.notify(status)
+
+	// ping watchdog only after ready and while healthy so systemd can restart us,
+	// startup is bounded by the start timeout of systemd instead
+	if systemdService.watchdogInterval > 0 && systemdService.notifiedReady {
+		aliveErr := systemdService.isAlive()
+		if aliveErr != nil {
+			logger.WithError(aliveErr).Error("service is not alive, skip watchdog ping")
+		} else {
+			systemdService.notify(daemon.SdNotifyWatchdog)
+		}
+	}
+}
+
+func (systemdService *SystemdService) notify(state string) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "SystemdService",
+		"function": "notify",
+	})
+
+	_, err := daemon.SdNotify(false, state)
+	if err != nil {
+		logger.WithError(err).Warnf("failed to notify %q to systemd", state)
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// listenNotifySocket listens on a fake NOTIFY_SOCKET
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on notify socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+	return conn
+}
+
+// readNotifications reads states notified until no more arrive
+func readNotifications(t *testing.T, conn *net.UnixConn) []string {
+	t.Helper()
+
+	states := []string{}
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return states
+		}
+		states = append(states, string(buf[:n]))
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	conn := listenNotifySocket(t)
+
+	var connectedErr error = NewServiceNotReadyError("no subscription to Nats")
+	var readyErr error = connectedErr
+	var aliveErr error
+
+	systemdService := &SystemdService{
+		service:          &S3DataWatcherService{},
+		isConnected:      func() error { return connectedErr },
+		isReady:          func() error { return readyErr },
+		isAlive:          func() error { return aliveErr },
+		watchdogInterval: time.Second,
+		terminateChan:    make(chan bool),
+	}
+
+	// not ready yet, no watchdog ping before ready
+	systemdService.update()
+	states := readNotifications(t, conn)
+	if len(states) != 1 || states[0] != "STATUS=connected=false, jobs running=0, events processed=0, not ready: no subscription to Nats" {
+		t.Fatalf("unexpected notifications before ready: %q", states)
+	}
+
+	// connected but the job file fails to load
+	connectedErr = nil
+	readyErr = NewServiceNotReadyError("failed to load jobs")
+	systemdService.update()
+	states = readNotifications(t, conn)
+	if len(states) != 1 || states[0] != "STATUS=connected=true, jobs running=0, events processed=0, not ready: failed to load jobs" {
+		t.Fatalf("unexpected notifications while jobs fail to load: %q", states)
+	}
+
+	// ready once
+	readyErr = nil
+	systemdService.update()
+	systemdService.update()
+	states = readNotifications(t, conn)
+	if len(states) != 5 || states[0] != "READY=1" || !strings.HasPrefix(states[1], "STATUS=connected=true") || states[2] != "WATCHDOG=1" {
+		t.Fatalf("unexpected notifications after ready: %q", states)
+	}
+
+	// no watchdog ping while stuck
+	aliveErr = xerrors.Errorf("event dispatcher is stuck")
+	systemdService.update()
+	states = readNotifications(t, conn)
+	if len(states) != 1 || !strings.HasPrefix(states[0], "STATUS=") {
+		t.Fatalf("unexpected notifications while stuck: %q", states)
+	}
+}
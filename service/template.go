@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-lambda-go/events"
+	"golang.org/x/xerrors"
+)
+
+// actionTemplateData is data given to templates of actions, e.g. {{ .Record.S3.Object.Key }}
+type actionTemplateData struct {
+	Job   string
+	RunID string
+	// of the first record
+	EventName string
+	Bucket    string
+	Key       string
+	Record    events.S3EventRecord // the first record
+	Records   []events.S3EventRecord
+	Payload   string // sent to commands via STDIN
+	// result of the job chaining this run, nil if not chained
+	Previous *JobRunResult
+}
+
+var actionTemplateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		valueJson, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(valueJson), nil
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    strings.ReplaceAll,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+}
+
+// parseActionTemplate parses the template of an action
+func parseActionTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(actionTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// newActionTemplateData returns template data of the run
+func newActionTemplateData(run *jobRun) *actionTemplateData {
+	return &actionTemplateData{
+		Job:       run.job.Name,
+		RunID:     run.id,
+		EventName: run.record.EventName,
+		Bucket:    run.record.S3.Bucket.Name,
+		Key:       run.record.S3.Object.Key,
+		Record:    run.record,
+		Records:   run.records,
+		Payload:   string(run.payload),
+		Previous:  run.previous,
+	}
+}
+
+// renderActionTemplate renders the template of an action with the run
+func renderActionTemplate(name string, text string, run *jobRun) (string, error) {
+	tmpl, err := parseActionTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := bytes.Buffer{}
+	err = tmpl.Execute(&buffer, newActionTemplateData(run))
+	if err != nil {
+		return "", xerrors.Errorf("failed to render %s template: %w", name, err)
+	}
+
+	return buffer.String(), nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cyverse/s3-data-watcher/commons"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/xerrors"
+)
+
+const (
+	tracerName             string        = "github.com/cyverse/s3-data-watcher/service"
+	tracingShutdownTimeout time.Duration = 5 * time.Second
+	traceParentEnvName     string        = "TRACEPARENT"
+	traceStateEnvName      string        = "TRACESTATE"
+	traceParentCarrierKey  string        = "traceparent"
+	traceStateCarrierKey   string        = "tracestate"
+)
+
+// tracer is a no-op tracer until TracingService sets the global provider
+var tracer = otel.Tracer(tracerName)
+
+// TracingService exports OpenTelemetry spans
+type TracingService struct {
+	service        *S3DataWatcherService
+	config         *commons.TracingConfig
+	tracerProvider *sdktrace.TracerProvider
+}
+
+// CreateTracingService creates a Tracing service object and sets the global tracer provider
+func CreateTracingService(service *S3DataWatcherService, config *commons.TracingConfig) (*TracingService, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "CreateTracingService",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	tracingService := &TracingService{
+		service: service,
+		config:  config,
+	}
+
+	// always propagate W3C trace context, even if we don't export spans
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case commons.TracingExporterOTLP:
+		options := []otlptracehttp.Option{}
+		if len(config.Endpoint) > 0 {
+			options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		otlpExporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		exporter = otlpExporter
+	case commons.TracingExporterStdout:
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		exporter = stdoutExporter
+	case "", commons.TracingExporterNone:
+		// disabled
+		return tracingService, nil
+	default:
+		return nil, xerrors.Errorf("unknown tracing exporter %q", config.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+		semconv.ServiceVersion(commons.GetReleaseVersion()),
+	))
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	tracingService.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(tracingService.tracerProvider)
+
+	logger.Infof("exporting traces via %s", config.Exporter)
+
+	return tracingService, nil
+}
+
+// Release releases all resources, flushing remaining spans
+func (tracingService *TracingService) Release() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"struct":   "TracingService",
+		"function": "Release",
+	})
+
+	defer commons.StackTraceFromPanic(logger)
+
+	if tracingService.tracerProvider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+
+	err := tracingService.tracerProvider.Shutdown(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("failed to flush traces")
+	}
+
+	tracingService.tracerProvider = nil
+}
+
+// natsHeaderCarrier adapts Nats message headers to TextMapCarrier
+// Nats headers are case-sensitive, but W3C trace context keys are not
+type natsHeaderCarrier nats.Header
+
+// Get returns the value associated with the key, ignoring case
+func (carrier natsHeaderCarrier) Get(key string) string {
+	for headerKey, values := range carrier {
+		if strings.EqualFold(headerKey, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// Set sets the value associated with the key
+func (carrier natsHeaderCarrier) Set(key string, value string) {
+	carrier[key] = []string{value}
+}
+
+// Keys returns keys in the carrier
+func (carrier natsHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// extractTraceContext returns a context carrying the trace context given in Nats message headers
+func extractTraceContext(header nats.Header) context.Context {
+	ctx := context.Background()
+	if header == nil {
+		return ctx
+	}
+
+	return otel.GetTextMapPropagator().Extract(ctx, natsHeaderCarrier(header))
+}
+
+// getTraceContextEnvs returns environment variables passing the trace context to jobs
+func getTraceContextEnvs(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	envs := []string{}
+	if traceParent := carrier.Get(traceParentCarrierKey); len(traceParent) > 0 {
+		envs = append(envs, traceParentEnvName+"="+traceParent)
+	}
+
+	if traceState := carrier.Get(traceStateCarrierKey); len(traceState) > 0 {
+		envs = append(envs, traceStateEnvName+"="+traceState)
+	}
+
+	return envs
+}
+
+// endSpanWithError records the error to the span and ends it
+func endSpanWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}